@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func compareCommand(flags *flag.FlagSet, args []string) error {
+	var baseline, candidate string
+	opts := tr.CompareOptions{}
+
+	flags.StringVar(&baseline, "baseline", "",
+		"Comma-separated trace, metrics CSV or metrics Parquet files or glob patterns of the baseline runs")
+	flags.StringVar(&candidate, "candidate", "",
+		"Comma-separated trace, metrics CSV or metrics Parquet files or glob patterns of the candidate runs")
+	flags.StringVar(&opts.FilenameColumn, "filenamecolumn", "tracefile",
+		"Column name to write trace filename to when computing metrics from trace files")
+	flags.Float64Var(&opts.Alpha, "alpha", 0.05, "Significance level of the Mann-Whitney U test")
+	flags.Float64Var(&opts.Threshold, "threshold", 0.05,
+		"Minimum relative increase of the median to report as a regression, such as 0.05 for 5%")
+	flags.StringVar(&opts.Format, "format", "table", "Output format: table, csv or json")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	baselineFiles, err := expandFileList(baseline)
+	if err != nil {
+		return err
+	}
+
+	candidateFiles, err := expandFileList(candidate)
+	if err != nil {
+		return err
+	}
+
+	return tr.Compare(baselineFiles, candidateFiles, opts, os.Stdout)
+}
+
+// Expands a comma-separated list of paths and glob patterns.
+func expandFileList(list string) ([]string, error) {
+	var files []string
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No files match %s", pattern)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Expected at least one file")
+	}
+	return files, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func criticalPathCommand(flags *flag.FlagSet, args []string) error {
+	var format string
+	flags.StringVar(&format, "format", "table", "Output format: table, csv or json")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	traceFiles := flags.Args()
+
+	return tr.CriticalPath(traceFiles, format, os.Stdout)
+}
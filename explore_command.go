@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func exploreCommand(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("Expected a single trace file to explore")
+	}
+
+	return tr.Explore(flags.Arg(0))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func filterCommand(flags *flag.FlagSet, args []string) error {
+	var inputFilePath, outputFilePath, keep, drop string
+
+	flags.StringVar(&inputFilePath, "from", "", "Path to the trace file")
+	flags.StringVar(
+		&outputFilePath,
+		"to",
+		"",
+		"Path where to write the filtered output trace file, compressed if ending in .gz or .zst",
+	)
+	flags.StringVar(&keep, "keep", "",
+		`Keep the spans matching this query with their subtrees and ancestors, e.g. 'name =~ "^/pulumirpc.ResourceProvider/" && duration > 1s'`)
+	flags.StringVar(&drop, "drop", "", "Drop the spans matching this query with their subtrees")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if (keep == "") == (drop == "") {
+		return fmt.Errorf("Expected exactly one of -keep and -drop")
+	}
+
+	mode, source := tr.KeepMatching, keep
+	if drop != "" {
+		mode, source = tr.DropMatching, drop
+	}
+
+	query, err := tr.ParseSpanQuery(source)
+	if err != nil {
+		return err
+	}
+
+	return tr.Filter(inputFilePath, outputFilePath, query, mode)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func flameGraphCommand(flags *flag.FlagSet, args []string) (err error) {
+	var outputFile string
+	var opts tr.FlameGraphOptions
+
+	flags.StringVar(&opts.Weight, "weight", tr.SelfFlameGraphWeight,
+		"Weight stacks by self time, total time (both in microseconds) or span count: self, total or count")
+	flags.BoolVar(&opts.CollapseIDs, "collapseids", false, "Replace IDs in span names so that similar spans fold together")
+	flags.BoolVar(&opts.FileFrames, "fileframes", false, "Add the trace file name as the bottom frame of every stack")
+	flags.StringVar(&outputFile, "out", "", "Write to this file instead of stdout")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if outputFile == "" {
+		return tr.FlameGraph(flags.Args(), os.Stdout, opts)
+	}
+
+	out, err := tr.CreateOutputFile(outputFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	return tr.FlameGraph(flags.Args(), out, opts)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func latencyCommand(flags *flag.FlagSet, args []string) error {
+	var format string
+	var opts tr.LatencyOptions
+	flags.StringVar(&format, "format", "table", "Output format: table, csv or json")
+	flags.BoolVar(&opts.GRPCOnly, "grpc", false, "Only report /pulumirpc.* gRPC method spans")
+	flags.BoolVar(&opts.CollapseIDs, "collapseids", false, "Replace IDs in span names so that similar spans are reported together")
+	flags.BoolVar(&opts.Union, "union", false,
+		"Also report the union time of the spans of every name per file, holding the spans of a file in memory")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return tr.Latency(flags.Args(), format, opts, os.Stdout)
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"regexp"
+	"runtime"
 	"strings"
+	"time"
 
 	tr "github.com/pulumi/pulumi-trace-tool/traces"
 )
@@ -16,38 +20,88 @@ type command struct {
 }
 
 func toCsvCommand(flags *flag.FlagSet, args []string) error {
-	var outputCsvFile, filenameColumn string
+	var outputCsvFile, filenameColumn, columnsFile string
+	var jobs int
+	var strictColumns, treeColumns bool
 
-	flags.StringVar(&outputCsvFile, "csv", "", "Path where to write the CSV output file")
+	flags.StringVar(&outputCsvFile, "csv", "", "Path where to write the CSV output file, compressed if ending in .gz or .zst")
 	flags.StringVar(&filenameColumn, "filenamecolumn", "tracefile", "Column name to write trace filename to")
+	flags.IntVar(&jobs, "jobs", runtime.NumCPU(), "Maximum number of trace files to decode concurrently")
+	flags.BoolVar(
+		&treeColumns,
+		"tree",
+		false,
+		"Add span tree columns: SpanID, ParentID, TraceID, Depth, RootName, ChildCount, DurationMs and SelfTimeMs",
+	)
+	addColumnOrderFlags(flags, &columnsFile, &strictColumns)
 
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
 
+	columns, err := loadColumnOrder(columnsFile, strictColumns)
+	if err != nil {
+		return err
+	}
+
 	traceFiles := flags.Args()
 
-	return tr.ToCsv(traceFiles, outputCsvFile, filenameColumn)
+	return tr.ToCsvWithOptions(traceFiles, outputCsvFile, tr.ToCsvOptions{
+		FilenameColumn: filenameColumn,
+		Jobs:           jobs,
+		Columns:        columns,
+		TreeColumns:    treeColumns,
+	})
+}
+
+func addColumnOrderFlags(flags *flag.FlagSet, columnsFile *string, strict *bool) {
+	flags.StringVar(columnsFile, "columns", "", "File listing CSV columns in the order to write them, one per line")
+	flags.BoolVar(strict, "strictcolumns", false, "Fail if the columns differ from the ones listed in -columns")
+}
+
+func loadColumnOrder(columnsFile string, strict bool) (*tr.ColumnOrder, error) {
+	if columnsFile == "" {
+		if strict {
+			return nil, fmt.Errorf("-strictcolumns requires -columns")
+		}
+		return nil, nil
+	}
+	return tr.LoadColumnOrder(columnsFile, strict)
 }
 
 func toParquetCommand(flags *flag.FlagSet, args []string) error {
-	var inputCsvFile, outputParquetFile string
+	var inputCsvFile, outputParquetFile, schemaFile string
 
 	flags.StringVar(&inputCsvFile, "csv", "", "Path where read the CSV metrics input file")
 	flags.StringVar(&outputParquetFile, "parquet", "", "Path where to write the Parquet file")
+	flags.StringVar(&schemaFile, "schema", "", "YAML or JSON Parquet schema; by default, infer column types from the data")
 
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
 
-	return tr.ToParquet(inputCsvFile, outputParquetFile)
+	var schema *tr.ParquetSchema
+	if schemaFile != "" {
+		var err error
+		schema, err = tr.LoadParquetSchema(schemaFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tr.ToParquetWithSchema(inputCsvFile, outputParquetFile, schema)
 }
 
 func removeLogsCommand(flags *flag.FlagSet, args []string) error {
 	var inputFilePath, outputFilePath string
 
 	flags.StringVar(&inputFilePath, "from", "", "Path to the trace file")
-	flags.StringVar(&outputFilePath, "to", "", "Path where to write the filtered output trace file")
+	flags.StringVar(
+		&outputFilePath,
+		"to",
+		"",
+		"Path where to write the filtered output trace file, compressed if ending in .gz or .zst",
+	)
 
 	if err := flags.Parse(args); err != nil {
 		return err
@@ -56,22 +110,67 @@ func removeLogsCommand(flags *flag.FlagSet, args []string) error {
 	return tr.RemoveLogs(inputFilePath, outputFilePath)
 }
 
-func extractLogsCommand(flags *flag.FlagSet, args []string) error {
+func extractLogsCommand(flags *flag.FlagSet, args []string) (err error) {
+	var format, outputFile, severities, since, until, msg string
+	flags.StringVar(&format, "format", "text", "Output format: text, jsonl or csv")
+	flags.StringVar(&outputFile, "out", "", "Write to this file instead of stdout, compressed if ending in .gz or .zst")
+	flags.StringVar(&severities, "severity", "", "Comma-separated severities to keep, e.g. warning,error")
+	flags.StringVar(&since, "since", "", "Keep messages logged at or after this RFC3339 time")
+	flags.StringVar(&until, "until", "", "Keep messages logged before this RFC3339 time")
+	flags.StringVar(&msg, "msg", "", "Keep messages matching this regular expression")
+
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
 
-	for _, f := range flags.Args() {
-		if err := tr.ExtractLogs(f); err != nil {
-			return err
+	var filter tr.LogFilter
+	if severities != "" {
+		for _, s := range strings.Split(severities, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				filter.Severities = append(filter.Severities, s)
+			}
+		}
+	}
+	if since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339Nano, since); err != nil {
+			return fmt.Errorf("Invalid -since: %w", err)
 		}
 	}
+	if until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339Nano, until); err != nil {
+			return fmt.Errorf("Invalid -until: %w", err)
+		}
+	}
+	if msg != "" {
+		if filter.Msg, err = regexp.Compile(msg); err != nil {
+			return fmt.Errorf("Invalid -msg: %w", err)
+		}
+	}
+
+	entries, err := tr.ReadLogs(flags.Args(), filter)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	if outputFile == "" {
+		return tr.WriteLogs(os.Stdout, entries, format)
+	}
+
+	out, err := tr.CreateOutputFile(outputFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return tr.WriteLogs(out, entries, format)
 }
 
-func metricsCommand(flags *flag.FlagSet, args []string) error {
-	var csvFile, filenameColumn, parquetFile string
+func metricsCommand(flags *flag.FlagSet, args []string) (err error) {
+	var csvFile, filenameColumn, parquetFile, format, outputFile, table, specFile, schemaFile, columnsFile string
+	var strictColumns bool
 
 	flags.StringVar(&csvFile, "csv", "", "CSV file with data to aggreate into metrics")
 	flags.StringVar(&filenameColumn, "filenamecolumn", "tracefile", "Column name where trace filename was recorded")
@@ -79,29 +178,123 @@ func metricsCommand(flags *flag.FlagSet, args []string) error {
 		&parquetFile,
 		"parquet",
 		"",
-		"Path to write metrics in parquet format to; by default, write CSV to stdout",
+		"Path to write metrics in parquet format to; same as -format parquet -out path",
 	)
+	flags.StringVar(&format, "format", "csv", "Output format: csv, jsonl, table, parquet or sqlite")
+	flags.StringVar(
+		&outputFile,
+		"out",
+		"",
+		"File to write metrics to, compressed if ending in .gz or .zst; required for parquet and sqlite, stdout otherwise",
+	)
+	flags.StringVar(&table, "table", "metrics", "Table to append metrics to with -format sqlite")
+	flags.StringVar(&specFile, "spec", "", "YAML or JSON file defining the metrics; by default, the built-in metrics")
+	flags.StringVar(&schemaFile, "schema", "", "YAML or JSON Parquet schema; by default, derived from the metrics spec")
+	addColumnOrderFlags(flags, &columnsFile, &strictColumns)
 
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
 
-	var sink tr.MetricsSink
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
 	if parquetFile != "" {
-		sink = tr.NewParquetFileMetricsSink(parquetFile)
-	} else {
-		sink = tr.NewCsvMetricsSink(os.Stdout)
+		if set["format"] || set["out"] {
+			return fmt.Errorf("-parquet cannot be combined with -format or -out")
+		}
+		format, outputFile = "parquet", parquetFile
+	}
+	if schemaFile != "" && format != "parquet" {
+		return fmt.Errorf("-schema is only supported with -format parquet")
+	}
+	if columnsFile != "" && format != "csv" {
+		return fmt.Errorf("-columns is only supported with -format csv")
+	}
+
+	columns, err := loadColumnOrder(columnsFile, strictColumns)
+	if err != nil {
+		return err
+	}
+
+	spec := tr.DefaultMetricsSpec()
+	if specFile != "" {
+		spec, err = tr.LoadMetricsSpec(specFile)
+		if err != nil {
+			return err
+		}
 	}
-	return tr.Metrics(csvFile, filenameColumn, sink)
+
+	var sink tr.MetricsSink
+	switch format {
+	case "parquet":
+		if outputFile == "" {
+			return fmt.Errorf("-out is required with -format parquet")
+		}
+		if schemaFile != "" {
+			schema, err := tr.LoadParquetSchema(schemaFile)
+			if err != nil {
+				return err
+			}
+			sink = tr.NewSchemaParquetFileMetricsSink(outputFile, schema)
+		} else {
+			sink = tr.NewSpecParquetFileMetricsSink(outputFile, spec)
+		}
+	case "sqlite":
+		if outputFile == "" {
+			return fmt.Errorf("-out is required with -format sqlite")
+		}
+		sink = tr.NewSqliteMetricsSink(outputFile, table)
+	case "csv", "jsonl", "table":
+		var out io.Writer = os.Stdout
+		if outputFile != "" {
+			f, createErr := tr.CreateOutputFile(outputFile)
+			if createErr != nil {
+				return createErr
+			}
+			defer func() {
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}()
+			out = f
+		}
+		switch format {
+		case "csv":
+			sink = tr.NewOrderedCsvMetricsSink(out, columns)
+		case "jsonl":
+			sink = tr.NewJsonLinesMetricsSink(out)
+		default:
+			sink = tr.NewTableMetricsSink(out)
+		}
+	default:
+		return fmt.Errorf("Unknown metrics format %q", format)
+	}
+
+	return tr.MetricsWithSpec(csvFile, filenameColumn, spec, sink)
 }
 
 var commands = map[string]command{
-	"tocsv":       {"tocsv", toCsvCommand},
-	"toparquet":   {"toparquet", toParquetCommand},
-	"removelogs":  {"removelogs", removeLogsCommand},
-	"extractlogs": {"extractlogs", extractLogsCommand},
-	"metrics":     {"metrics", metricsCommand},
-	"summary":     {"summary", summaryCommand},
+	"tocsv":        {"tocsv", toCsvCommand},
+	"toparquet":    {"toparquet", toParquetCommand},
+	"removelogs":   {"removelogs", removeLogsCommand},
+	"filter":       {"filter", filterCommand},
+	"merge":        {"merge", mergeCommand},
+	"split":        {"split", splitCommand},
+	"redact":       {"redact", redactCommand},
+	"explore":      {"explore", exploreCommand},
+	"report":       {"report", reportCommand},
+	"flamegraph":   {"flamegraph", flameGraphCommand},
+	"memstats":     {"memstats", memStatsCommand},
+	"latency":      {"latency", latencyCommand},
+	"extractlogs":  {"extractlogs", extractLogsCommand},
+	"metrics":      {"metrics", metricsCommand},
+	"summary":      {"summary", summaryCommand},
+	"toperfetto":   {"toperfetto", toPerfettoCommand},
+	"tojaeger":     {"tojaeger", toJaegerCommand},
+	"tootlp":       {"tootlp", toOtlpCommand},
+	"criticalpath": {"criticalpath", criticalPathCommand},
+	"resources":    {"resources", resourcesCommand},
+	"compare":      {"compare", compareCommand},
 }
 
 func main() {
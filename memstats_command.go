@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func memStatsCommand(flags *flag.FlagSet, args []string) error {
+	var format string
+	var summary bool
+	flags.StringVar(&format, "format", "csv", "Output format: table, csv or json")
+	flags.BoolVar(&summary, "summary", false,
+		"Write the growth rate, peak and GC pause percentiles per process instead of the samples")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return tr.MemStats(flags.Args(), format, summary, os.Stdout)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func mergeCommand(flags *flag.FlagSet, args []string) error {
+	var outputFilePath string
+	var opts tr.MergeOptions
+
+	flags.StringVar(
+		&outputFilePath,
+		"to",
+		"",
+		"Path where to write the merged trace file, compressed if ending in .gz or .zst",
+	)
+	flags.BoolVar(&opts.Reroot, "reroot", false,
+		"Put the spans of every input file under a span named after the file, all in one trace")
+	flags.BoolVar(&opts.RenameCollisions, "rename", false,
+		"Give the spans of an input file new IDs when they collide with spans of earlier files")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return tr.Merge(flags.Args(), outputFilePath, opts)
+}
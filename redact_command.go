@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func redactCommand(flags *flag.FlagSet, args []string) error {
+	var inputFilePath, outputFilePath, rulesFile, salt string
+
+	flags.StringVar(&inputFilePath, "from", "", "Path to the trace file")
+	flags.StringVar(
+		&outputFilePath,
+		"to",
+		"",
+		"Path where to write the redacted output trace file, compressed if ending in .gz or .zst",
+	)
+	flags.StringVar(&rulesFile, "rules", "",
+		"YAML or JSON file with the redact rules; defaults to rules for known Pulumi annotations")
+	flags.StringVar(&salt, "salt", "", "Secret mixed into hashed values so that they cannot be guessed")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	spec := tr.DefaultRedactSpec()
+	if rulesFile != "" {
+		var err error
+		spec, err = tr.LoadRedactSpec(rulesFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tr.Redact(inputFilePath, outputFilePath, spec, salt)
+}
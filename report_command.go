@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func reportCommand(flags *flag.FlagSet, args []string) (err error) {
+	var outputFile string
+	var opts tr.ReportOptions
+
+	flags.StringVar(&outputFile, "out", "report.html", "Path to the HTML report, compressed if ending in .gz or .zst")
+	flags.StringVar(&opts.Title, "title", "", "Title of the report")
+	flags.IntVar(&opts.MaxSpans, "maxspans", 0, "Maximum number of spans drawn per trace file; 2000 by default")
+	flags.IntVar(&opts.MaxTopSpans, "maxtop", 0, "Maximum number of spans listed by self time; 25 by default")
+	flags.IntVar(&opts.MaxLogs, "maxlogs", 0, "Maximum number of log messages shown; 1000 by default")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	out, err := tr.CreateOutputFile(outputFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	return tr.Report(flags.Args(), out, opts)
+}
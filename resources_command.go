@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func resourcesCommand(flags *flag.FlagSet, args []string) error {
+	var format string
+	var byType bool
+
+	flags.StringVar(&format, "format", "table", "Output format: table, csv or json")
+	flags.BoolVar(&byType, "bytype", false, "Aggregate resources by type across all trace files")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	traceFiles := flags.Args()
+
+	return tr.Resources(traceFiles, byType, format, os.Stdout)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func splitCommand(flags *flag.FlagSet, args []string) error {
+	var opts tr.SplitOptions
+
+	flags.StringVar(&opts.By, "by", tr.SplitByTrace, "Split by root trace, pulumi process or time window: trace, process or window")
+	flags.DurationVar(&opts.Window, "window", 0, "Length of the time windows when splitting by window, e.g. 30s")
+	flags.StringVar(&opts.Template, "template", tr.DefaultSplitTemplate,
+		"Output file names as a Go template of .Input (the input file name without extensions), .Key and .Index")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	for _, f := range flags.Args() {
+		files, err := tr.Split(f, opts)
+		if err != nil {
+			return err
+		}
+		for _, out := range files {
+			fmt.Println(out)
+		}
+	}
+
+	return nil
+}
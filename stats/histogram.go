@@ -0,0 +1,157 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Relative accuracy of the quantiles of NewHistogram(0).
+const DefaultHistogramAccuracy = 0.01
+
+// A histogram with logarithmically sized buckets that estimates the
+// quantiles of a sample within a relative error, in space growing with
+// the logarithm of the range of the values rather than with the sample
+// size. Histograms of the same accuracy merge without loss, so partial
+// histograms, such as per trace file, combine into an exact histogram
+// of the whole.
+//
+// Values at or below zero share a single bucket estimated as zero. The
+// count, sum, minimum and maximum are exact.
+type Histogram struct {
+	accuracy float64
+	logGamma float64
+	gamma    float64
+
+	// Bucket i counts the values in (gamma^(i-1), gamma^i].
+	buckets map[int]uint64
+	zeros   uint64
+
+	count    uint64
+	sum      float64
+	min, max float64
+}
+
+// Creates a histogram estimating quantiles within the relative accuracy,
+// a fraction between 0 and 1; DefaultHistogramAccuracy applies if it is
+// out of that range.
+func NewHistogram(relativeAccuracy float64) *Histogram {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		relativeAccuracy = DefaultHistogramAccuracy
+	}
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &Histogram{
+		accuracy: relativeAccuracy,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		buckets:  make(map[int]uint64),
+	}
+}
+
+func (h *Histogram) Add(x float64) {
+	if h.count == 0 || x < h.min {
+		h.min = x
+	}
+	if h.count == 0 || x > h.max {
+		h.max = x
+	}
+	h.count++
+	h.sum += x
+
+	if x <= 0 {
+		h.zeros++
+		return
+	}
+	h.buckets[int(math.Ceil(math.Log(x)/h.logGamma))]++
+}
+
+// Adds the values of another histogram of the same accuracy.
+func (h *Histogram) Merge(other *Histogram) error {
+	if other.accuracy != h.accuracy {
+		return fmt.Errorf("Cannot merge histograms of accuracy %v and %v", h.accuracy, other.accuracy)
+	}
+	if other.count == 0 {
+		return nil
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if h.count == 0 || other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+	h.zeros += other.zeros
+	for i, n := range other.buckets {
+		h.buckets[i] += n
+	}
+	return nil
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+func (h *Histogram) Sum() float64 {
+	return h.sum
+}
+
+// Returns NaN for an empty histogram, as do Min, Max and Quantile.
+func (h *Histogram) Mean() float64 {
+	if h.count == 0 {
+		return math.NaN()
+	}
+	return h.sum / float64(h.count)
+}
+
+func (h *Histogram) Min() float64 {
+	if h.count == 0 {
+		return math.NaN()
+	}
+	return h.min
+}
+
+func (h *Histogram) Max() float64 {
+	if h.count == 0 {
+		return math.NaN()
+	}
+	return h.max
+}
+
+// Estimates the q-th quantile (0 <= q <= 1) as the value of rank
+// q*(count-1), rounded down; the 0th and 1st quantiles are the exact
+// minimum and maximum.
+func (h *Histogram) Quantile(q float64) float64 {
+	switch {
+	case h.count == 0:
+		return math.NaN()
+	case q <= 0:
+		return h.min
+	case q >= 1:
+		return h.max
+	}
+
+	clamp := func(x float64) float64 {
+		return math.Min(math.Max(x, h.min), h.max)
+	}
+
+	rank := uint64(q * float64(h.count-1))
+	seen := h.zeros
+	if rank < seen {
+		return clamp(0)
+	}
+
+	indexes := make([]int, 0, len(h.buckets))
+	for i := range h.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		seen += h.buckets[i]
+		if rank < seen {
+			// The value within accuracy of every value of the bucket.
+			return clamp(2 * math.Pow(h.gamma, float64(i)) / (h.gamma + 1))
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,94 @@
+// Summary statistics and significance tests for comparing benchmark
+// samples.
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// Computes the q-th quantile (0 <= q <= 1) of the sample, linearly
+// interpolating between the closest ranks. Returns NaN for an empty
+// sample.
+func Quantile(sample []float64, q float64) float64 {
+	if len(sample) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), sample...)
+	sort.Float64s(sorted)
+
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	frac := pos - float64(lo)
+	return sorted[lo] + (sorted[hi]-sorted[lo])*frac
+}
+
+func Median(sample []float64) float64 {
+	return Quantile(sample, 0.5)
+}
+
+// Result of a Mann-Whitney U test.
+type MannWhitneyResult struct {
+	// U statistic of the first sample.
+	U float64
+
+	// Two-sided p-value of the hypothesis that the samples come from
+	// the same distribution.
+	P float64
+}
+
+// Runs a two-sided Mann-Whitney U test (also known as the Wilcoxon
+// rank-sum test) on two independent samples. The p-value uses the
+// normal approximation with tie and continuity corrections, which is
+// reasonable from about 5 observations per sample; with fewer it is
+// only a rough guide.
+func MannWhitneyU(a, b []float64) MannWhitneyResult {
+	n1, n2 := len(a), len(b)
+	if n1 == 0 || n2 == 0 {
+		return MannWhitneyResult{P: 1}
+	}
+
+	type obs struct {
+		value float64
+		first bool
+	}
+	all := make([]obs, 0, n1+n2)
+	for _, x := range a {
+		all = append(all, obs{x, true})
+	}
+	for _, x := range b {
+		all = append(all, obs{x, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// Assign average ranks to ties and accumulate the tie correction.
+	var rankSum, tieSum float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		tieSum += t*t*t - t
+		i = j
+	}
+
+	fn1, fn2 := float64(n1), float64(n2)
+	n := fn1 + fn2
+	u := rankSum - fn1*(fn1+1)/2
+	mu := fn1 * fn2 / 2
+	sigma := math.Sqrt(fn1 * fn2 / 12 * ((n + 1) - tieSum/(n*(n-1))))
+	if sigma == 0 {
+		return MannWhitneyResult{U: u, P: 1}
+	}
+
+	z := math.Max(math.Abs(u-mu)-0.5, 0) / sigma
+	return MannWhitneyResult{U: u, P: math.Erfc(z / math.Sqrt2)}
+}
@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuantile(t *testing.T) {
+	sample := []float64{4, 1, 3, 2, 5}
+	assert.Equal(t, 3.0, Median(sample))
+	assert.Equal(t, 4.6, Quantile(sample, 0.9))
+	assert.Equal(t, 2.5, Median([]float64{1, 2, 3, 4}))
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	r := MannWhitneyU([]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10})
+	assert.Equal(t, 0.0, r.U)
+	assert.InDelta(t, 0.01219, r.P, 1e-5)
+
+	same := MannWhitneyU([]float64{1, 1, 1}, []float64{1, 1})
+	assert.Equal(t, 1.0, same.P)
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(0)
+	assert.True(t, math.IsNaN(h.Quantile(0.5)))
+
+	var sample []float64
+	for i := 1; i <= 1000; i++ {
+		sample = append(sample, float64(i*i))
+	}
+	for _, x := range sample {
+		h.Add(x)
+	}
+	assert.Equal(t, uint64(1000), h.Count())
+	assert.Equal(t, 1.0, h.Quantile(0))
+	assert.Equal(t, 1e6, h.Quantile(1))
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+		exact := sample[int(q*999)]
+		assert.InEpsilon(t, exact, h.Quantile(q), DefaultHistogramAccuracy, "q=%v", q)
+	}
+
+	// Merging halves gives the histogram of the whole.
+	a, b := NewHistogram(0), NewHistogram(0)
+	for i, x := range sample {
+		if i%2 == 0 {
+			a.Add(x)
+		} else {
+			b.Add(x)
+		}
+	}
+	a.Add(0)
+	h.Add(0)
+	assert.NoError(t, a.Merge(b))
+	assert.Equal(t, h, a)
+	assert.Equal(t, 0.0, a.Quantile(0))
+
+	assert.Error(t, a.Merge(NewHistogram(0.05)))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func toJaegerCommand(flags *flag.FlagSet, args []string) error {
+	var inputFilePath, outputFilePath string
+
+	flags.StringVar(&inputFilePath, "from", "", "Path to the trace file")
+	flags.StringVar(&outputFilePath, "to", "", "Path where to write the Jaeger JSON file; by default, write to stdout")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return tr.ToJaeger(inputFilePath, outputFilePath)
+}
+
+func toOtlpCommand(flags *flag.FlagSet, args []string) error {
+	var inputFilePath, outputFilePath, pushEndpoint string
+
+	flags.StringVar(&inputFilePath, "from", "", "Path to the trace file")
+	flags.StringVar(&outputFilePath, "to", "", "Path where to write the OTLP JSON file; by default, write to stdout")
+	flags.StringVar(&pushEndpoint, "push", "",
+		"OTLP/HTTP endpoint to send the spans to instead of writing a file, such as http://localhost:4318")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if pushEndpoint != "" {
+		return tr.PushOtlp(inputFilePath, pushEndpoint)
+	}
+
+	return tr.ToOtlp(inputFilePath, outputFilePath)
+}
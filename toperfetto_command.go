@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+
+	tr "github.com/pulumi/pulumi-trace-tool/traces"
+)
+
+func toPerfettoCommand(flags *flag.FlagSet, args []string) error {
+	var inputFilePath, outputFilePath string
+
+	flags.StringVar(&inputFilePath, "from", "", "Path to the trace file")
+	flags.StringVar(&outputFilePath, "to", "",
+		"Path where to write the Chrome Trace Event JSON file; by default, write to stdout")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return tr.ToPerfetto(inputFilePath, outputFilePath)
+}
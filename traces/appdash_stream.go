@@ -0,0 +1,540 @@
+// Streams the spans of an appdash trace file without materializing the
+// whole MemoryStore.
+//
+// The file is a gob stream holding a single memoryStoreData value:
+//
+//	type memoryStoreData struct {
+//		Trace map[ID]*Trace
+//		Span  map[ID]map[ID]*Trace
+//	}
+//
+// Every span is a value of the Span map, keyed by trace and span ID.
+// The Trace map repeats the roots and both maps repeat every subtree in
+// the Sub field of its parent, so the decoder skips the Trace map and
+// Sub fields byte by byte and only decodes the ID and annotations of
+// each span as it goes.
+
+package traces
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Calls onSpan for every span in an appdash trace file, in the order
+// the spans were written. Other trace formats are read into memory.
+func streamSpansFromFile(file string, onSpan func(span *appdash.Span) error) error {
+	reader, f, err := openInputFile(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	format, err := detectTraceFormat(reader)
+	if err != nil {
+		return fmt.Errorf("Failed to detect trace format of %s: %w", file, err)
+	}
+
+	if format != appdashTraceFormat {
+		return walkTracesFromFile(file, func(t *appdash.Trace) error {
+			return onSpan(&t.Span)
+		})
+	}
+
+	if err := streamAppdashSpans(reader, onSpan); err != nil {
+		return fmt.Errorf("Failed to decode %s: %w", file, err)
+	}
+	return nil
+}
+
+func streamAppdashSpans(r *bufio.Reader, onSpan func(span *appdash.Span) error) error {
+	d := &gobStreamDecoder{r: r, types: make(map[int64]*gobWireType)}
+	return d.decodeMemoryStoreData(onSpan)
+}
+
+// Gob type IDs predefined by encoding/gob.
+const (
+	gobBoolID      = 1
+	gobIntID       = 2
+	gobUintID      = 3
+	gobFloatID     = 4
+	gobBytesID     = 5
+	gobStringID    = 6
+	gobComplexID   = 7
+	gobInterfaceID = 8
+)
+
+// The parts of a gob wireType needed to walk values of the type.
+type gobWireType struct {
+	kind   gobKind
+	elem   int64
+	key    int64
+	fields []gobField
+}
+
+type gobKind int
+
+const (
+	gobArray gobKind = iota + 1
+	gobSlice
+	gobStruct
+	gobMap
+	gobEncoder
+)
+
+type gobField struct {
+	name string
+	id   int64
+}
+
+type gobStreamDecoder struct {
+	r     *bufio.Reader
+	types map[int64]*gobWireType
+}
+
+// Reads type definitions up to the value message and decodes the value
+// as a memoryStoreData, yielding the spans of its Span map.
+func (d *gobStreamDecoder) decodeMemoryStoreData(onSpan func(span *appdash.Span) error) error {
+	for {
+		if _, err := d.readUint(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("no value in gob stream")
+			}
+			return err
+		}
+		id, err := d.readInt()
+		if err != nil {
+			return err
+		}
+		if id < 0 {
+			wt, err := d.readWireType()
+			if err != nil {
+				return err
+			}
+			d.types[-id] = wt
+			continue
+		}
+
+		data, err := d.structType(id)
+		if err != nil {
+			return err
+		}
+		return d.walkStruct(data, func(f gobField) error {
+			if f.name != "Span" {
+				return d.skip(f.id)
+			}
+			traces, err := d.wireType(f.id, gobMap)
+			if err != nil {
+				return err
+			}
+			return d.walkMap(traces, func() error {
+				spans, err := d.wireType(traces.elem, gobMap)
+				if err != nil {
+					return err
+				}
+				return d.walkMap(spans, func() error {
+					span, err := d.decodeTrace(spans.elem)
+					if err != nil {
+						return err
+					}
+					return onSpan(span)
+				})
+			})
+		})
+	}
+}
+
+// Decodes the span of a Trace value, skipping its Sub traces.
+func (d *gobStreamDecoder) decodeTrace(id int64) (*appdash.Span, error) {
+	trace, err := d.structType(id)
+	if err != nil {
+		return nil, err
+	}
+
+	span := &appdash.Span{}
+	err = d.walkStruct(trace, func(f gobField) error {
+		if f.name != "Span" {
+			return d.skip(f.id)
+		}
+		st, err := d.structType(f.id)
+		if err != nil {
+			return err
+		}
+		return d.walkStruct(st, func(f gobField) error {
+			switch f.name {
+			case "ID":
+				return d.decodeSpanID(f.id, &span.ID)
+			case "Annotations":
+				return d.decodeAnnotations(f.id, &span.Annotations)
+			default:
+				return d.skip(f.id)
+			}
+		})
+	})
+	return span, err
+}
+
+func (d *gobStreamDecoder) decodeSpanID(id int64, spanID *appdash.SpanID) error {
+	st, err := d.structType(id)
+	if err != nil {
+		return err
+	}
+	return d.walkStruct(st, func(f gobField) error {
+		var out *appdash.ID
+		switch f.name {
+		case "Trace":
+			out = &spanID.Trace
+		case "Span":
+			out = &spanID.Span
+		case "Parent":
+			out = &spanID.Parent
+		default:
+			return d.skip(f.id)
+		}
+		x, err := d.readUint()
+		*out = appdash.ID(x)
+		return err
+	})
+}
+
+func (d *gobStreamDecoder) decodeAnnotations(id int64, anns *appdash.Annotations) error {
+	slice, err := d.wireType(id, gobSlice)
+	if err != nil {
+		return err
+	}
+	elem, err := d.structType(slice.elem)
+	if err != nil {
+		return err
+	}
+	n, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		var a appdash.Annotation
+		err := d.walkStruct(elem, func(f gobField) error {
+			switch f.name {
+			case "Key":
+				b, err := d.readBytes()
+				a.Key = string(b)
+				return err
+			case "Value":
+				b, err := d.readBytes()
+				a.Value = b
+				return err
+			default:
+				return d.skip(f.id)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		*anns = append(*anns, a)
+	}
+	return nil
+}
+
+func (d *gobStreamDecoder) wireType(id int64, kind gobKind) (*gobWireType, error) {
+	wt, ok := d.types[id]
+	if !ok || wt.kind != kind {
+		return nil, fmt.Errorf("unexpected gob type %d", id)
+	}
+	return wt, nil
+}
+
+func (d *gobStreamDecoder) structType(id int64) (*gobWireType, error) {
+	return d.wireType(id, gobStruct)
+}
+
+// Calls onField for every field sent for a struct value; onField must
+// consume the field value.
+func (d *gobStreamDecoder) walkStruct(st *gobWireType, onField func(f gobField) error) error {
+	field := -1
+	for {
+		delta, err := d.readUint()
+		if err != nil {
+			return err
+		}
+		if delta == 0 {
+			return nil
+		}
+		if delta > uint64(len(st.fields)) {
+			return fmt.Errorf("gob field out of range")
+		}
+		field += int(delta)
+		if field >= len(st.fields) {
+			return fmt.Errorf("gob field out of range")
+		}
+		if err := onField(st.fields[field]); err != nil {
+			return err
+		}
+	}
+}
+
+// Calls onEntry for every entry of a map value; onEntry must consume
+// the element, the key has already been skipped.
+func (d *gobStreamDecoder) walkMap(mt *gobWireType, onEntry func() error) error {
+	n, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := d.skip(mt.key); err != nil {
+			return err
+		}
+		if err := onEntry(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Consumes a value of the given type without decoding it.
+func (d *gobStreamDecoder) skip(id int64) error {
+	switch id {
+	case gobBoolID, gobIntID, gobUintID, gobFloatID:
+		_, err := d.readUint()
+		return err
+	case gobComplexID:
+		if _, err := d.readUint(); err != nil {
+			return err
+		}
+		_, err := d.readUint()
+		return err
+	case gobBytesID, gobStringID:
+		return d.skipBytes()
+	case gobInterfaceID:
+		name, err := d.readBytes()
+		if err != nil || len(name) == 0 {
+			return err
+		}
+		if _, err := d.readInt(); err != nil {
+			return err
+		}
+		return d.skipBytes()
+	}
+
+	wt, ok := d.types[id]
+	if !ok {
+		return fmt.Errorf("unknown gob type %d", id)
+	}
+
+	switch wt.kind {
+	case gobArray, gobSlice:
+		n, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := d.skip(wt.elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	case gobMap:
+		return d.walkMap(wt, func() error {
+			return d.skip(wt.elem)
+		})
+	case gobStruct:
+		return d.walkStruct(wt, func(f gobField) error {
+			return d.skip(f.id)
+		})
+	default:
+		return d.skipBytes()
+	}
+}
+
+// Decodes a wireType value:
+//
+//	type wireType struct {
+//		ArrayT           *arrayType      // {CommonType; Elem typeId; Len int}
+//		SliceT           *sliceType      // {CommonType; Elem typeId}
+//		StructT          *structType     // {CommonType; Field []fieldType}
+//		MapT             *mapType        // {CommonType; Key typeId; Elem typeId}
+//		GobEncoderT      *gobEncoderType // {CommonType}
+//		BinaryMarshalerT *gobEncoderType
+//		TextMarshalerT   *gobEncoderType
+//	}
+//	type CommonType struct { Name string; Id typeId }
+//	type fieldType struct { Name string; Id typeId }
+func (d *gobStreamDecoder) readWireType() (*gobWireType, error) {
+	wt := &gobWireType{}
+
+	// Reads the fields of a struct value by number, starting at 1.
+	readFields := func(onField func(n int) error) error {
+		n := 0
+		for {
+			delta, err := d.readUint()
+			if err != nil {
+				return err
+			}
+			if delta == 0 {
+				return nil
+			}
+			n += int(delta)
+			if err := onField(n); err != nil {
+				return err
+			}
+		}
+	}
+
+	skipCommonType := func() error {
+		return readFields(func(n int) error {
+			switch n {
+			case 1:
+				return d.skipBytes()
+			case 2:
+				_, err := d.readInt()
+				return err
+			default:
+				return fmt.Errorf("unexpected gob CommonType field %d", n)
+			}
+		})
+	}
+
+	readTypeID := func(out *int64) error {
+		x, err := d.readInt()
+		*out = x
+		return err
+	}
+
+	err := readFields(func(kind int) error {
+		if kind < 1 || kind > 7 {
+			return fmt.Errorf("unexpected gob wireType field %d", kind)
+		}
+		wt.kind = []gobKind{gobArray, gobSlice, gobStruct, gobMap, gobEncoder, gobEncoder, gobEncoder}[kind-1]
+
+		return readFields(func(n int) error {
+			if n == 1 {
+				return skipCommonType()
+			}
+			switch {
+			case wt.kind == gobArray && n == 2, wt.kind == gobSlice && n == 2, wt.kind == gobMap && n == 3:
+				return readTypeID(&wt.elem)
+			case wt.kind == gobArray && n == 3:
+				_, err := d.readInt()
+				return err
+			case wt.kind == gobMap && n == 2:
+				return readTypeID(&wt.key)
+			case wt.kind == gobStruct && n == 2:
+				count, err := d.readLength()
+				if err != nil {
+					return err
+				}
+				for i := 0; i < count; i++ {
+					var f gobField
+					err := readFields(func(n int) error {
+						switch n {
+						case 1:
+							name, err := d.readBytes()
+							f.name = string(name)
+							return err
+						case 2:
+							return readTypeID(&f.id)
+						default:
+							return fmt.Errorf("unexpected gob fieldType field %d", n)
+						}
+					})
+					if err != nil {
+						return err
+					}
+					wt.fields = append(wt.fields, f)
+				}
+				return nil
+			default:
+				return fmt.Errorf("unexpected gob type definition field %d", n)
+			}
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if wt.kind == 0 {
+		return nil, fmt.Errorf("empty gob type definition")
+	}
+	return wt, nil
+}
+
+// Reads a gob unsigned integer: either a single byte below 128, or a
+// negated byte count followed by big-endian bytes.
+func (d *gobStreamDecoder) readUint() (uint64, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b < 0x80 {
+		return uint64(b), nil
+	}
+	n := int(-int8(b))
+	if n > 8 {
+		return 0, fmt.Errorf("invalid gob uint")
+	}
+	var x uint64
+	for i := 0; i < n; i++ {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		x = x<<8 | uint64(c)
+	}
+	return x, nil
+}
+
+// Reads a gob signed integer, which has the sign in the low bit.
+func (d *gobStreamDecoder) readInt() (int64, error) {
+	x, err := d.readUint()
+	if err != nil {
+		return 0, noEOF(err)
+	}
+	if x&1 != 0 {
+		return ^int64(x >> 1), nil
+	}
+	return int64(x >> 1), nil
+}
+
+func (d *gobStreamDecoder) readLength() (int, error) {
+	n, err := d.readUint()
+	if err != nil {
+		return 0, noEOF(err)
+	}
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid gob length %d", n)
+	}
+	return int(n), nil
+}
+
+func (d *gobStreamDecoder) readBytes() ([]byte, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return nil, noEOF(err)
+	}
+	return buf, nil
+}
+
+func (d *gobStreamDecoder) skipBytes() error {
+	n, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	if _, err := d.r.Discard(n); err != nil {
+		return noEOF(err)
+	}
+	return nil
+}
+
+// An EOF in the middle of a value means the file is truncated.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package traces
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func TestStreamAppdashSpans(t *testing.T) {
+	spans := testPulumiSpans()
+	for i := 0; i < 100; i++ {
+		spans = append(spans, testSpan{
+			id: uint64(100 + i), parent: 4, name: "/pulumirpc.ResourceProvider/Check",
+			start: 4000 + i, end: 4001 + i,
+			attrs: map[string]string{"urn": fmt.Sprintf("urn:pulumi:dev::p::t::r%d", i)},
+		})
+	}
+	file := writeTestTrace(t, "up.trace", spans)
+
+	expected := make(map[appdash.SpanID]map[string]string)
+	require.NoError(t, walkTracesFromFile(file, func(tr *appdash.Trace) error {
+		expected[tr.Span.ID] = tr.Span.Annotations.StringMap()
+		return nil
+	}))
+
+	actual := make(map[appdash.SpanID]map[string]string)
+	require.NoError(t, streamSpansFromFile(file, func(span *appdash.Span) error {
+		_, dup := actual[span.ID]
+		assert.False(t, dup, "span %v streamed twice", span.ID)
+		actual[span.ID] = span.Annotations.StringMap()
+		return nil
+	}))
+
+	assert.Len(t, actual, len(spans))
+	assert.Equal(t, expected, actual)
+}
+
+func TestStreamAppdashSpansTruncated(t *testing.T) {
+	memStore := appdash.NewMemoryStore()
+	collectTestSpans(t, memStore, 1, testPulumiSpans())
+	var buf bytes.Buffer
+	require.NoError(t, memStore.Write(&buf))
+
+	data := buf.Bytes()[:buf.Len()-10]
+	err := streamAppdashSpans(bufio.NewReader(bytes.NewReader(data)), func(*appdash.Span) error {
+		return nil
+	})
+	assert.Error(t, err)
+}
@@ -2,11 +2,15 @@ package traces
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	// "flag"
-	// "fmt"
+	"fmt"
+	"io"
 	// "log"
-	"os"
 
+	"github.com/pulumi/pulumi-trace-tool/intervals"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 	"sourcegraph.com/sourcegraph/appdash"
 )
 
@@ -33,36 +37,70 @@ func walkTraces(traces []*appdash.Trace, onTrace func(x *appdash.Trace) error) e
 }
 
 func walkTracesFromFile(file string, onTrace func(x *appdash.Trace) error) error {
-	memStore, err := readMemoryStore(file)
+	traces, err := readTracesFromFile(file)
 	if err != nil {
 		return err
 	}
 
-	traces, err := memStore.Traces(appdash.TracesOpts{})
+	return walkTraces(traces, onTrace)
+}
+
+// Reads the root traces (span trees) recorded in a file.
+func readTracesFromFile(file string) ([]*appdash.Trace, error) {
+	memStore, err := readMemoryStore(file)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return walkTraces(traces, onTrace)
+	return memStore.Traces(appdash.TracesOpts{})
 }
 
+// Parses the `Span.Start` and `Span.End` annotations of a span.
+func traceInterval(trace *appdash.Trace) (intervals.Interval, error) {
+	return spanInterval(trace.Span.Annotations.StringMap())
+}
+
+// Writes the store to a file, compressed if the extension asks for it
+// (see CreateOutputFile).
 func writeMemoryStore(filepath string, memStore *appdash.MemoryStore) error {
-	f, err := os.Create(filepath)
+	f, err := CreateOutputFile(filepath)
 	if err != nil {
 		return err
 	}
-	return memStore.Write(f)
+	out := bufio.NewWriter(f)
+	if err := memStore.Write(out); err != nil {
+		contract.IgnoreClose(f)
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		contract.IgnoreClose(f)
+		return err
+	}
+	return f.Close()
 }
 
 func readMemoryStore(filePath string) (*appdash.MemoryStore, error) {
 	memStore := appdash.NewMemoryStore()
 
-	inputFile, err := os.Open(filePath)
+	reader, inputFile, err := openInputFile(filePath)
 	if err != nil {
 		return nil, err
 	}
+	defer inputFile.Close()
+
+	format, err := detectTraceFormat(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to detect trace format of %s: %w", filePath, err)
+	}
 
-	_, err = memStore.ReadFrom(bufio.NewReader(inputFile))
+	switch format {
+	case otlpJsonTraceFormat:
+		err = readOtlpJson(reader, memStore)
+	case otlpProtoTraceFormat:
+		err = readOtlpProto(reader, memStore)
+	default:
+		_, err = memStore.ReadFrom(reader)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -70,11 +108,147 @@ func readMemoryStore(filePath string) (*appdash.MemoryStore, error) {
 	return memStore, nil
 }
 
+type traceFormat int
+
+const (
+	// Gob-encoded appdash MemoryStore written by `pulumi --tracing file:`.
+	appdashTraceFormat traceFormat = iota
+
+	// OpenTelemetry traces in the OTLP JSON encoding.
+	otlpJsonTraceFormat
+
+	// OpenTelemetry traces in the OTLP protobuf encoding.
+	otlpProtoTraceFormat
+)
+
+// Sniffs the leading bytes of a trace file without consuming them.
+//
+// OTLP JSON starts with an object. An appdash file is a gob stream,
+// which starts with a message defining a type. OTLP protobuf starts
+// with the tag of the `resource_spans` field.
+func detectTraceFormat(reader *bufio.Reader) (traceFormat, error) {
+	head, err := reader.Peek(64)
+	if err != nil && err != io.EOF {
+		return appdashTraceFormat, err
+	}
+
+	trimmed := bytes.TrimLeft(head, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return otlpJsonTraceFormat, nil
+	}
+
+	if looksLikeGob(head) {
+		return appdashTraceFormat, nil
+	}
+
+	if len(head) > 0 && head[0] == 0x0a {
+		return otlpProtoTraceFormat, nil
+	}
+
+	// Let the gob decoder report the error.
+	return appdashTraceFormat, nil
+}
+
+// Checks for the start of a gob type definition message: a byte
+// count, a negative user type ID (user IDs start at 65), and a
+// `wireType` struct whose first field is a struct starting with a
+// `CommonType` and its `Name`.
+func looksLikeGob(head []byte) bool {
+	// Decodes a gob unsigned integer: either a single byte below
+	// 128, or a negated byte count followed by big-endian bytes.
+	readUint := func() (uint64, bool) {
+		if len(head) == 0 {
+			return 0, false
+		}
+		b := head[0]
+		head = head[1:]
+		if b < 0x80 {
+			return uint64(b), true
+		}
+		n := int(-int8(b))
+		if n > 8 || n > len(head) {
+			return 0, false
+		}
+		var x uint64
+		for _, c := range head[:n] {
+			x = x<<8 | uint64(c)
+		}
+		head = head[n:]
+		return x, true
+	}
+
+	if _, ok := readUint(); !ok {
+		return false
+	}
+
+	// Signed integers have the sign in the low bit.
+	typeID, ok := readUint()
+	if !ok || typeID&1 != 1 || typeID>>1 < 64 {
+		return false
+	}
+
+	wireTypeField, ok := readUint()
+	if !ok || wireTypeField < 1 || wireTypeField > 7 {
+		return false
+	}
+
+	commonTypeField, ok := readUint()
+	if !ok || commonTypeField != 1 {
+		return false
+	}
+
+	nameField, ok := readUint()
+	return ok && nameField == 1
+}
+
 func isEngineLogTrace(trace *appdash.Trace) bool {
-	for _, ann := range trace.Annotations {
+	return isEngineLogSpan(&trace.Span)
+}
+
+func isEngineLogSpan(span *appdash.Span) bool {
+	for _, ann := range span.Annotations {
 		if ann.Key == "Name" && string(ann.Value) == "/pulumirpc.Engine/Log" {
 			return true
 		}
 	}
 	return false
 }
+
+// Keys of the payloads Pulumi logs as the Msg of the spans of its gRPC
+// calls, such as `{"gRPC request":{...}}`.
+const (
+	grpcRequestPayload  = "gRPC request"
+	grpcResponsePayload = "gRPC response"
+)
+
+// Decodes the first gRPC payload of the kind logged on a span; nil if
+// there is none, such as when payload logging was disabled with
+// PULUMI_TRACING_NO_PAYLOADS.
+func spanGRPCPayload(anns appdash.Annotations, kind string) map[string]interface{} {
+	for _, a := range anns {
+		if a.Key != logMsgAnnotation {
+			continue
+		}
+		if payload, ok := decodeGRPCPayload(a.Value, kind); ok {
+			return payload
+		}
+	}
+	return nil
+}
+
+// Decodes a logged message holding a gRPC payload of the kind.
+func decodeGRPCPayload(msg []byte, kind string) (map[string]interface{}, bool) {
+	var payloads map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &payloads); err != nil {
+		return nil, false
+	}
+	raw, ok := payloads[kind]
+	if !ok {
+		return nil, false
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(raw, &payload); err != nil || payload == nil {
+		return nil, false
+	}
+	return payload, true
+}
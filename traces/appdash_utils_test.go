@@ -0,0 +1,111 @@
+package traces
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+var testTraceStart = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// A span to record in a test trace; start and end are offsets in
+// milliseconds from testTraceStart. The logs are recorded as log events
+// at the start of the span, in order.
+type testSpan struct {
+	id, parent uint64
+	name       string
+	start, end int
+	attrs      map[string]string
+	logs       []string
+}
+
+func collectTestSpans(t *testing.T, memStore *appdash.MemoryStore, trace uint64, spans []testSpan) {
+	for _, s := range spans {
+		id := appdash.SpanID{Trace: appdash.ID(trace), Span: appdash.ID(s.id), Parent: appdash.ID(s.parent)}
+
+		anns, err := appdash.MarshalEvent(appdash.SpanName(s.name))
+		require.NoError(t, err)
+		for k, v := range s.attrs {
+			anns = append(anns, appdash.Annotation{Key: k, Value: []byte(v)})
+		}
+		for _, msg := range s.logs {
+			log, err := appdash.MarshalEvent(appdash.LogWithTimestamp(msg,
+				testTraceStart.Add(time.Duration(s.start)*time.Millisecond)))
+			require.NoError(t, err)
+			anns = append(anns, log...)
+		}
+		ts, err := appdash.MarshalEvent(appdash.Timespan{
+			S: testTraceStart.Add(time.Duration(s.start) * time.Millisecond),
+			E: testTraceStart.Add(time.Duration(s.end) * time.Millisecond),
+		})
+		require.NoError(t, err)
+		anns = append(anns, ts...)
+
+		require.NoError(t, memStore.Collect(id, anns...))
+	}
+}
+
+// A gRPC payload as Pulumi logs it on the span of a call, e.g.
+// testGRPCPayload(grpcRequestPayload, `{"urn":"..."}`).
+func testGRPCPayload(kind, payload string) string {
+	return `{"` + kind + `":` + payload + `}`
+}
+
+// A small `pulumi up` shaped trace.
+func testPulumiSpans() []testSpan {
+	return []testSpan{
+		{id: 1, name: "pulumi", start: 0, end: 10000, attrs: map[string]string{
+			"benchmark_name": "test",
+			"os.Args":        "[pulumi up --yes]",
+		}},
+		{id: 2, parent: 1, name: "pulumi-plan", start: 1000, end: 9000},
+		{id: 3, parent: 2, name: "/pulumirpc.ResourceMonitor/RegisterResource", start: 2000, end: 5000},
+		{id: 4, parent: 2, name: "/pulumirpc.ResourceMonitor/RegisterResource", start: 3000, end: 8000},
+		{id: 5, parent: 4, name: "/pulumirpc.ResourceProvider/Create", start: 4000, end: 7500},
+		{id: 6, parent: 2, name: "/pulumirpc.Engine/Log", start: 6000, end: 6001, attrs: map[string]string{
+			"Msg":  "hello",
+			"Time": "2023-01-01T00:00:06Z",
+		}},
+		{id: 7, parent: 1, name: "api/patchCheckpoint", start: 9000, end: 9500, attrs: map[string]string{
+			"api": "https://api.pulumi.com",
+		}},
+	}
+}
+
+// Writes an appdash trace file with the given spans under trace ID 1.
+func writeTestTrace(t *testing.T, name string, spans []testSpan) string {
+	memStore := appdash.NewMemoryStore()
+	collectTestSpans(t, memStore, 1, spans)
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, writeMemoryStore(file, memStore))
+	return file
+}
+
+func TestDetectAppdashTraceFormat(t *testing.T) {
+	memStore := appdash.NewMemoryStore()
+	collectTestSpans(t, memStore, 1, testPulumiSpans())
+
+	var buf bytes.Buffer
+	require.NoError(t, memStore.Write(&buf))
+
+	format, err := detectTraceFormat(bufio.NewReader(&buf))
+	require.NoError(t, err)
+	assert.Equal(t, appdashTraceFormat, format)
+}
+
+func TestWalkAppdashTrace(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+
+	n := 0
+	require.NoError(t, walkTracesFromFile(file, func(*appdash.Trace) error {
+		n++
+		return nil
+	}))
+	assert.Equal(t, len(testPulumiSpans()), n)
+}
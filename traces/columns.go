@@ -0,0 +1,132 @@
+package traces
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Columns that lead CSV output, in this order, when present. All other
+// columns follow sorted by name.
+var wellKnownColumns = []string{
+	"Name",
+	"Span.Start",
+	"Span.End",
+	spanIDColumn,
+	parentIDColumn,
+	traceIDColumn,
+	depthColumn,
+	rootNameColumn,
+	childCountColumn,
+	durationColumn,
+	selfTimeColumn,
+	benchmark_name,
+	benchmark_phase,
+	benchmark_start,
+}
+
+// Orders columns deterministically: well-known columns first, then the
+// rest sorted by name.
+func orderColumns(columns []string) []string {
+	present := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		present[c] = true
+	}
+
+	var res []string
+	for _, c := range wellKnownColumns {
+		if present[c] {
+			res = append(res, c)
+			delete(present, c)
+		}
+	}
+
+	var rest []string
+	for c := range present {
+		rest = append(rest, c)
+	}
+	sort.Strings(rest)
+
+	return append(res, rest...)
+}
+
+// Pins the order of CSV columns, loaded with LoadColumnOrder.
+type ColumnOrder struct {
+	// Columns in the order to write them. Pinned columns missing from
+	// the data are written empty.
+	Columns []string
+
+	// Fail when the observed columns differ from the pinned ones,
+	// rather than appending unpinned columns in the default order.
+	Strict bool
+}
+
+// Loads a column order from a file with one column name per line.
+// Blank lines and lines starting with # are ignored.
+func LoadColumnOrder(path string, strict bool) (*ColumnOrder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	order := &ColumnOrder{Strict: strict}
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if seen[line] {
+			return nil, fmt.Errorf("Duplicate column %q in %s", line, path)
+		}
+		seen[line] = true
+		order.Columns = append(order.Columns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// Orders the observed columns. Without a pinned order, or with a nil
+// order, this is orderColumns.
+func (o *ColumnOrder) apply(observed []string) ([]string, error) {
+	if o == nil || len(o.Columns) == 0 {
+		return orderColumns(observed), nil
+	}
+
+	pinned := make(map[string]bool, len(o.Columns))
+	for _, c := range o.Columns {
+		pinned[c] = true
+	}
+
+	present := make(map[string]bool, len(observed))
+	var unpinned []string
+	for _, c := range observed {
+		present[c] = true
+		if !pinned[c] {
+			unpinned = append(unpinned, c)
+		}
+	}
+
+	if o.Strict {
+		var missing []string
+		for _, c := range o.Columns {
+			if !present[c] {
+				missing = append(missing, c)
+			}
+		}
+		if len(missing) > 0 || len(unpinned) > 0 {
+			sort.Strings(unpinned)
+			return nil, fmt.Errorf("Columns drifted from the pinned order; new: [%s], missing: [%s]",
+				strings.Join(unpinned, ", "), strings.Join(missing, ", "))
+		}
+	}
+
+	res := append([]string{}, o.Columns...)
+	return append(res, orderColumns(unpinned)...), nil
+}
@@ -0,0 +1,56 @@
+package traces
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderColumns(t *testing.T) {
+	assert.Equal(t,
+		[]string{"Name", "Span.Start", "Span.End", "api", "os.Args", "zone"},
+		orderColumns([]string{"zone", "Span.End", "os.Args", "Name", "api", "Span.Start"}))
+}
+
+func TestColumnOrder(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "columns.txt")
+	require.NoError(t, os.WriteFile(file, []byte("# pinned\nb\n\na\nmissing\n"), 0o600))
+
+	order, err := LoadColumnOrder(file, false)
+	require.NoError(t, err)
+	columns, err := order.apply([]string{"c", "a", "Name", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a", "missing", "Name", "c"}, columns)
+
+	order.Strict = true
+	_, err = order.apply([]string{"c", "a", "b"})
+	assert.ErrorContains(t, err, "new: [c], missing: [missing]")
+
+	_, err = order.apply([]string{"missing", "a", "b"})
+	assert.NoError(t, err)
+}
+
+func TestCsvColumnOrder(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+	out := filepath.Join(t.TempDir(), "traces.csv")
+	require.NoError(t, ToCsv([]string{file}, out, "file"))
+
+	f, err := os.Open(out)
+	require.NoError(t, err)
+	defer f.Close()
+	header, err := csv.NewReader(f).Read()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Name", "Span.Start", "Span.End", "benchmark_name"}, header[:4])
+	assert.Equal(t, "file", header[len(header)-1])
+
+	var buf bytes.Buffer
+	require.NoError(t, NewCsvMetricsSink(&buf).WriteMetrics([]map[string]string{
+		{time_total_ms: "1", benchmark_phase: "p", benchmark_name: "n", "a": "x"},
+	}))
+	assert.Equal(t, "benchmark_name,benchmark_phase,a,time_total_ms\nn,p,x,1\n", buf.String())
+}
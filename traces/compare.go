@@ -0,0 +1,286 @@
+// Compares benchmark metrics of a baseline and a candidate set of runs
+// to detect performance regressions.
+
+package traces
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi-trace-tool/stats"
+)
+
+// Returned by Compare when at least one metric regressed.
+var ErrRegression = errors.New("performance regression detected")
+
+type CompareOptions struct {
+	// Column where trace filenames are recorded when computing
+	// metrics from trace files.
+	FilenameColumn string
+
+	// Significance level of the Mann-Whitney U test.
+	Alpha float64
+
+	// Minimum relative increase of the median, such as 0.05 for 5%,
+	// for a significant difference to count as a regression.
+	Threshold float64
+
+	// Output format: table, csv or json.
+	Format string
+}
+
+// Comparison of one metric of one benchmark phase.
+type MetricComparison struct {
+	BenchmarkName  string
+	BenchmarkPhase string
+	Metric         string
+
+	BaselineN, CandidateN           int
+	BaselineMedian, CandidateMedian float64
+	BaselineP90, CandidateP90       float64
+
+	// Difference of the medians, candidate minus baseline.
+	Delta float64
+
+	// Delta relative to the baseline median; NaN when the baseline
+	// median is zero.
+	RelativeDelta float64
+
+	// Two-sided p-value of the Mann-Whitney U test.
+	P float64
+
+	Regression bool
+}
+
+// Compares the `time_*_ms` and `mem_*` metrics of the baseline and
+// candidate runs, grouped by benchmark_name and benchmark_phase, and
+// writes a report to w. Inputs may be trace files, metrics CSV files
+// as written by `metrics`, or metrics Parquet files.
+//
+// A metric regresses when the candidate median exceeds the baseline
+// median by more than the threshold and the difference is significant
+// at the given level. Higher is worse for every compared metric. Note
+// that with a single run on either side no difference is significant.
+// Returns ErrRegression after writing the report if any metric
+// regressed.
+func Compare(baselineFiles, candidateFiles []string, opts CompareOptions, w io.Writer) error {
+	if err := checkOutputFormat(opts.Format); err != nil {
+		return err
+	}
+
+	baseline, err := loadMetrics(baselineFiles, opts.FilenameColumn)
+	if err != nil {
+		return fmt.Errorf("Failed to load baseline metrics: %w", err)
+	}
+
+	candidate, err := loadMetrics(candidateFiles, opts.FilenameColumn)
+	if err != nil {
+		return fmt.Errorf("Failed to load candidate metrics: %w", err)
+	}
+
+	comparisons := CompareMetrics(baseline, candidate, opts.Alpha, opts.Threshold)
+
+	columns := []string{
+		benchmark_name, benchmark_phase, "metric",
+		"baseline_n", "baseline_median", "baseline_p90",
+		"candidate_n", "candidate_median", "candidate_p90",
+		"delta", "delta_pct", "p_value", "regression",
+	}
+
+	regressed := false
+	var records []map[string]interface{}
+	for _, c := range comparisons {
+		deltaPct := ""
+		if !math.IsNaN(c.RelativeDelta) {
+			deltaPct = strconv.FormatFloat(math.Round(c.RelativeDelta*10000)/100, 'f', -1, 64)
+		}
+		records = append(records, map[string]interface{}{
+			benchmark_name:     c.BenchmarkName,
+			benchmark_phase:    c.BenchmarkPhase,
+			"metric":           c.Metric,
+			"baseline_n":       c.BaselineN,
+			"baseline_median":  c.BaselineMedian,
+			"baseline_p90":     c.BaselineP90,
+			"candidate_n":      c.CandidateN,
+			"candidate_median": c.CandidateMedian,
+			"candidate_p90":    c.CandidateP90,
+			"delta":            c.Delta,
+			"delta_pct":        deltaPct,
+			"p_value":          math.Round(c.P*10000) / 10000,
+			"regression":       c.Regression,
+		})
+		regressed = regressed || c.Regression
+	}
+
+	if err := writeRecords(w, opts.Format, columns, records); err != nil {
+		return err
+	}
+
+	if regressed {
+		return ErrRegression
+	}
+	return nil
+}
+
+// Compares metrics rows as produced by Metrics. Only groups and metrics
+// present on both sides are compared.
+func CompareMetrics(baseline, candidate []map[string]string, alpha, threshold float64) []MetricComparison {
+	type groupKey struct {
+		name, phase, metric string
+	}
+
+	samples := func(rows []map[string]string) map[groupKey][]float64 {
+		res := make(map[groupKey][]float64)
+		for _, row := range rows {
+			for k, v := range row {
+				if !isComparableMetric(k) || v == "" {
+					continue
+				}
+				x, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					continue
+				}
+				key := groupKey{row[benchmark_name], row[benchmark_phase], k}
+				res[key] = append(res[key], x)
+			}
+		}
+		return res
+	}
+
+	base := samples(baseline)
+	cand := samples(candidate)
+
+	var keys []groupKey
+	for k := range base {
+		if _, ok := cand[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.phase != b.phase {
+			return a.phase < b.phase
+		}
+		return a.metric < b.metric
+	})
+
+	var res []MetricComparison
+	for _, k := range keys {
+		b, c := base[k], cand[k]
+		mc := MetricComparison{
+			BenchmarkName:   k.name,
+			BenchmarkPhase:  k.phase,
+			Metric:          k.metric,
+			BaselineN:       len(b),
+			CandidateN:      len(c),
+			BaselineMedian:  stats.Median(b),
+			CandidateMedian: stats.Median(c),
+			BaselineP90:     stats.Quantile(b, 0.9),
+			CandidateP90:    stats.Quantile(c, 0.9),
+			P:               stats.MannWhitneyU(b, c).P,
+		}
+		mc.Delta = mc.CandidateMedian - mc.BaselineMedian
+		mc.RelativeDelta = math.NaN()
+		if mc.BaselineMedian != 0 {
+			mc.RelativeDelta = mc.Delta / mc.BaselineMedian
+		}
+
+		exceeds := mc.Delta > 0
+		if !math.IsNaN(mc.RelativeDelta) {
+			exceeds = mc.RelativeDelta > threshold
+		}
+		mc.Regression = exceeds && mc.P < alpha
+
+		res = append(res, mc)
+	}
+
+	return res
+}
+
+func isCsvFile(path string) bool {
+	for _, ext := range []string{".csv", ".csv.gz", ".csv.zst", ".csv.zstd"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func isComparableMetric(column string) bool {
+	return strings.HasPrefix(column, "mem_") ||
+		(strings.HasPrefix(column, "time_") && strings.HasSuffix(column, "_ms"))
+}
+
+// Loads metrics rows from metrics CSV and Parquet files, computing them
+// from any other (trace) files.
+func loadMetrics(files []string, filenameColumn string) ([]map[string]string, error) {
+	var rows []map[string]string
+	var traceFiles []string
+
+	for _, f := range files {
+		switch {
+		case isCsvFile(f):
+			err := readLargeCsvFile(f, func(row map[string]string) error {
+				rows = append(rows, row)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(f, ".parquet") || strings.HasSuffix(f, ".parquet.snappy"):
+			data, err := readParquetMetrics(f)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, data...)
+		default:
+			traceFiles = append(traceFiles, f)
+		}
+	}
+
+	if len(traceFiles) > 0 {
+		data, err := metricsFromTraceFiles(traceFiles, filenameColumn)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, data...)
+	}
+
+	return rows, nil
+}
+
+// Computes metrics for trace files, going through a temporary CSV file
+// like the `summary` command.
+func metricsFromTraceFiles(traceFiles []string, filenameColumn string) ([]map[string]string, error) {
+	dir, err := os.MkdirTemp("", "pulumi-trace-tool")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { noErr(os.RemoveAll(dir)) }()
+
+	tracesCsv := filepath.Join(dir, "traces.csv")
+	if err := ToCsv(traceFiles, tracesCsv, filenameColumn); err != nil {
+		return nil, fmt.Errorf("Failed converting trace files to CSV: %w", err)
+	}
+
+	var data []map[string]string
+	sink := MetricsSinkFunc(func(rows []map[string]string) error {
+		data = rows
+		return nil
+	})
+	if err := Metrics(tracesCsv, filenameColumn, sink); err != nil {
+		return nil, fmt.Errorf("Failed to compute metrics: %w", err)
+	}
+
+	return data, nil
+}
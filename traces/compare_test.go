@@ -0,0 +1,132 @@
+package traces
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Metrics rows of six runs of a benchmark phase; metrics map to the
+// value of the first run, increasing by one per run.
+func testMetricsRuns(name, phase string, metrics map[string]float64) []map[string]string {
+	var rows []map[string]string
+	for i := 0; i < 6; i++ {
+		row := map[string]string{benchmark_name: name, benchmark_phase: phase}
+		for k, v := range metrics {
+			row[k] = strconv.FormatFloat(v+float64(i), 'f', -1, 64)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestCompareMetrics(t *testing.T) {
+	baseline := append(testMetricsRuns("app", "update", map[string]float64{
+		time_total_ms:  100,
+		time_engine_ms: 1000,
+		"mem_heap":     0,
+	}), testMetricsRuns("app", "preview", map[string]float64{time_total_ms: 100})...)
+	baseline = append(baseline, testMetricsRuns("gone", "update", map[string]float64{time_total_ms: 100})...)
+
+	candidate := append(testMetricsRuns("app", "update", map[string]float64{
+		time_total_ms:  150,
+		time_engine_ms: 1010,
+		"mem_heap":     10,
+		"time_new_ms":  1,
+	}), testMetricsRuns("app", "preview", map[string]float64{time_total_ms: 100})...)
+
+	// Zero the baseline of mem_heap, and leave a value missing.
+	for _, row := range baseline[:6] {
+		row["mem_heap"] = "0"
+	}
+	baseline[0][time_engine_ms] = ""
+
+	comparisons := CompareMetrics(baseline, candidate, 0.05, 0.05)
+	byKey := make(map[string]MetricComparison)
+	var keys []string
+	for _, c := range comparisons {
+		key := c.BenchmarkName + "/" + c.BenchmarkPhase + "/" + c.Metric
+		keys = append(keys, key)
+		byKey[key] = c
+	}
+
+	// Only groups and metrics on both sides, ordered by name, phase and
+	// metric.
+	assert.Equal(t, []string{
+		"app/preview/time_total_ms",
+		"app/update/mem_heap",
+		"app/update/time_engine_ms",
+		"app/update/time_total_ms",
+	}, keys)
+
+	total := byKey["app/update/time_total_ms"]
+	assert.Equal(t, 6, total.BaselineN)
+	assert.Equal(t, 102.5, total.BaselineMedian)
+	assert.Equal(t, 152.5, total.CandidateMedian)
+	assert.Equal(t, 50.0, total.Delta)
+	assert.InDelta(t, 0.4878, total.RelativeDelta, 1e-4)
+	assert.Less(t, total.P, 0.05)
+	assert.True(t, total.Regression)
+
+	// Significant, but under the threshold.
+	engine := byKey["app/update/time_engine_ms"]
+	assert.Equal(t, 5, engine.BaselineN, "missing values are skipped")
+	assert.Less(t, engine.P, 0.05)
+	assert.Less(t, engine.RelativeDelta, 0.05)
+	assert.False(t, engine.Regression)
+
+	// Any significant increase over a zero baseline regresses.
+	mem := byKey["app/update/mem_heap"]
+	assert.True(t, math.IsNaN(mem.RelativeDelta))
+	assert.Equal(t, 12.5, mem.Delta)
+	assert.True(t, mem.Regression)
+
+	preview := byKey["app/preview/time_total_ms"]
+	assert.Equal(t, 0.0, preview.Delta)
+	assert.False(t, preview.Regression)
+}
+
+func TestCompareFiles(t *testing.T) {
+	dir := t.TempDir()
+	baselineRows := testMetricsRuns("app", "update", map[string]float64{time_total_ms: 100})
+
+	baselineCsv := filepath.Join(dir, "baseline.csv")
+	var buf bytes.Buffer
+	require.NoError(t, NewCsvMetricsSink(&buf).WriteMetrics(baselineRows))
+	require.NoError(t, os.WriteFile(baselineCsv, buf.Bytes(), 0o600))
+
+	candidateParquet := filepath.Join(dir, "candidate.parquet")
+	candidateRows := testMetricsRuns("app", "update", map[string]float64{time_total_ms: 200})
+	require.NoError(t, NewParquetFileMetricsSink(candidateParquet).WriteMetrics(candidateRows))
+
+	opts := CompareOptions{Alpha: 0.05, Threshold: 0.05, Format: jsonOutputFormat}
+
+	buf.Reset()
+	err := Compare([]string{baselineCsv}, []string{candidateParquet}, opts, &buf)
+	assert.True(t, errors.Is(err, ErrRegression), "%v", err)
+
+	var rows []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rows))
+	require.Len(t, rows, 1)
+	assert.Equal(t, "app", rows[0][benchmark_name])
+	assert.Equal(t, "update", rows[0][benchmark_phase])
+	assert.Equal(t, time_total_ms, rows[0]["metric"])
+	assert.Equal(t, 102.5, rows[0]["baseline_median"])
+	assert.Equal(t, 202.5, rows[0]["candidate_median"])
+	assert.Equal(t, "97.56", rows[0]["delta_pct"])
+	assert.Equal(t, true, rows[0]["regression"])
+
+	// Against itself nothing regresses.
+	buf.Reset()
+	require.NoError(t, Compare([]string{candidateParquet}, []string{candidateParquet}, opts, &buf))
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rows))
+	assert.Equal(t, false, rows[0]["regression"])
+}
@@ -0,0 +1,141 @@
+// Transparent gzip and zstd compression of trace and CSV files.
+
+package traces
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Opens a file for reading, decompressing it if it starts with the gzip
+// or zstd magic bytes regardless of its extension.
+func openInputFile(filePath string) (*bufio.Reader, io.Closer, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, closer, err := decompress(bufio.NewReader(f), f)
+	if err != nil {
+		contract.IgnoreClose(f)
+		return nil, nil, err
+	}
+	return reader, closer, nil
+}
+
+func decompress(reader *bufio.Reader, f io.Closer) (*bufio.Reader, io.Closer, error) {
+	head, err := reader.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReader(gz), &compressedFile{gz, f}, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReader(zr), &compressedFile{zr.IOReadCloser(), f}, nil
+	default:
+		return reader, f, nil
+	}
+}
+
+// Creates a file for writing, compressing what is written with gzip if
+// the path ends in .gz or with zstd if it ends in .zst or .zstd. Close
+// must be called to flush the compressed stream.
+func CreateOutputFile(filePath string) (io.WriteCloser, error) {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(filePath, ".gz"):
+		return &compressedWriter{gzip.NewWriter(f), f}, nil
+	case strings.HasSuffix(filePath, ".zst"), strings.HasSuffix(filePath, ".zstd"):
+		zw, err := zstd.NewWriter(f)
+		if err != nil {
+			contract.IgnoreClose(f)
+			return nil, err
+		}
+		return &compressedWriter{zw, f}, nil
+	default:
+		return f, nil
+	}
+}
+
+// A compression stream over a file. Closing it closes the stream and
+// then the file.
+type compressedFile struct {
+	stream io.Closer
+	file   io.Closer
+}
+
+func (c *compressedFile) Close() error {
+	err := c.stream.Close()
+	if ferr := c.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+type compressedWriter struct {
+	stream io.WriteCloser
+	file   io.Closer
+}
+
+func (c *compressedWriter) Write(p []byte) (int, error) {
+	return c.stream.Write(p)
+}
+
+// Flushes the compressed stream and closes the file.
+func (c *compressedWriter) Close() error {
+	return (&compressedFile{c.stream, c.file}).Close()
+}
+
+// Extensions of compressed files, as appended to the name of the file
+// they compress.
+var compressionExtensions = []string{".gz", ".zst", ".zstd"}
+
+// Strips compression and trace file extensions, e.g. `up.trace.gz` to
+// `up`.
+func trimTraceFileExtensions(name string) string {
+	for _, ext := range compressionExtensions {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// Strips the `.trace` extension of an appdash trace file name and the
+// compression extension following it if any, e.g. `up.trace.gz` to
+// `up`; false if the name has no such extensions.
+func trimAppdashTraceExtension(name string) (string, bool) {
+	for _, ext := range compressionExtensions {
+		if trimmed := strings.TrimSuffix(name, ext); trimmed != name {
+			name = trimmed
+			break
+		}
+	}
+	trimmed := strings.TrimSuffix(name, ".trace")
+	return trimmed, trimmed != name
+}
@@ -0,0 +1,75 @@
+package traces
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedTraces(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+	dir := t.TempDir()
+
+	for _, ext := range []string{".gz", ".zst"} {
+		t.Run(ext, func(t *testing.T) {
+			// The compressed output has no hint of compression in its
+			// name, detection relies on the magic bytes.
+			compressed := filepath.Join(dir, "nolog.trace"+ext)
+			require.NoError(t, RemoveLogs(file, compressed))
+			renamed := filepath.Join(dir, "nolog"+ext+".trace")
+			require.NoError(t, os.Rename(compressed, renamed))
+
+			// Spans are keyed by name, so both RegisterResource
+			// spans count once.
+			spans := collectSpans(t, renamed)
+			assert.Len(t, spans, 5)
+			assert.NotContains(t, spans, "/pulumirpc.Engine/Log")
+
+			csvFile := filepath.Join(dir, "traces.csv"+ext)
+			require.NoError(t, ToCsv([]string{renamed}, csvFile, "file"))
+
+			var names []string
+			require.NoError(t, readLargeCsvFile(csvFile, func(row map[string]string) error {
+				names = append(names, row["Name"])
+				return nil
+			}))
+			assert.Len(t, names, 6)
+			assert.Contains(t, names, "pulumi")
+
+			// The phase is inferred from the name of compressed
+			// traces too.
+			phased := filepath.Join(dir, "test-pulumi-update.trace"+ext)
+			require.NoError(t, RemoveLogs(file, phased))
+			require.NoError(t, ToCsv([]string{phased}, csvFile, "file"))
+			var rows []map[string]string
+			require.NoError(t, Metrics(csvFile, "file", MetricsSinkFunc(func(data []map[string]string) error {
+				rows = data
+				return nil
+			})))
+			require.Len(t, rows, 1)
+			assert.Equal(t, "test", rows[0][benchmark_name])
+			assert.Equal(t, "pulumi-update", rows[0][benchmark_phase])
+		})
+	}
+}
+
+func TestTrimAppdashTraceExtension(t *testing.T) {
+	for name, want := range map[string]string{
+		"up.trace":      "up",
+		"up.trace.gz":   "up",
+		"up.trace.zst":  "up",
+		"up.trace.zstd": "up",
+	} {
+		trimmed, ok := trimAppdashTraceExtension(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, want, trimmed, name)
+	}
+
+	for _, name := range []string{"up", "up.json", "up.csv", "up.otlp.json", "up.gz", "up.trace.gz.zst", "up.trace.json"} {
+		_, ok := trimAppdashTraceExtension(name)
+		assert.False(t, ok, name)
+	}
+}
@@ -0,0 +1,213 @@
+// Computes the critical path of a trace: the chain of spans that
+// determined when the root span finished.
+
+package traces
+
+import (
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// A span on the critical path.
+type CriticalPathStep struct {
+	// Trace file the span was read from.
+	File string
+
+	SpanID string
+	Name   string
+
+	// Depth of the span in the trace tree; the root is 0.
+	Depth int
+
+	// Start of the span relative to the start of the root span.
+	Offset time.Duration
+
+	// Duration of the whole span.
+	Duration time.Duration
+
+	// Time on the critical path spent in this span itself rather than
+	// in one of its children.
+	SelfTime time.Duration
+
+	// SelfTime as a fraction of the duration of the root span.
+	Share float64
+}
+
+// Finds the critical path of every trace file and writes it to w as a
+// table, CSV or JSON (see writeRecords).
+func CriticalPath(traceFiles []string, format string, w io.Writer) error {
+	if err := checkOutputFormat(format); err != nil {
+		return err
+	}
+
+	var records []map[string]interface{}
+	for _, f := range traceFiles {
+		steps, err := ComputeCriticalPath(f)
+		if err != nil {
+			return err
+		}
+		for i, s := range steps {
+			records = append(records, map[string]interface{}{
+				"file":        s.File,
+				"step":        i + 1,
+				"depth":       s.Depth,
+				"span_id":     s.SpanID,
+				"name":        s.Name,
+				"offset_ms":   msFloat(s.Offset),
+				"duration_ms": msFloat(s.Duration),
+				"self_ms":     msFloat(s.SelfTime),
+				"share_pct":   math.Round(s.Share*10000) / 100,
+			})
+		}
+	}
+
+	columns := []string{"file", "step", "depth", "span_id", "name", "offset_ms", "duration_ms", "self_ms", "share_pct"}
+	return writeRecords(w, format, columns, records)
+}
+
+// Computes the critical path of the trace rooted at the `pulumi` span,
+// or at the longest root span if there is no `pulumi` span.
+//
+// Starting at the end of the root span, the path walks backwards
+// through time: at every point it descends into the child that
+// finished last before the current point, and attributes the gaps
+// where no child was running to the parent. Children running
+// concurrently with the chosen child are not on the path. Steps are
+// returned in the order the spans start on the path; a span that is on
+// the path several times (between its children) is reported once with
+// its self time summed.
+func ComputeCriticalPath(traceFile string) ([]CriticalPathStep, error) {
+	traces, err := readTracesFromFile(traceFile)
+	if err != nil {
+		return nil, err
+	}
+
+	root := criticalPathRoot(buildSpanTree(traces))
+	if root == nil {
+		return nil, nil
+	}
+
+	type segment struct {
+		node       *spanNode
+		start, end time.Time
+	}
+	var segments []segment
+
+	// Segments are appended from the latest to the earliest. The
+	// floor and cutoff clip children that run outside of their parent.
+	var visit func(n *spanNode, floor, cutoff time.Time)
+	visit = func(n *spanNode, floor, cutoff time.Time) {
+		start := n.interval.Start
+		if floor.After(start) {
+			start = floor
+		}
+		cursor := n.interval.End
+		if cutoff.Before(cursor) {
+			cursor = cutoff
+		}
+
+		children := timedChildren(n)
+		for cursor.After(start) {
+			// The child finishing last before the cursor.
+			var next *spanNode
+			var nextEnd time.Time
+			for _, c := range children {
+				if !c.interval.Start.Before(cursor) {
+					continue
+				}
+				end := c.interval.End
+				if end.After(cursor) {
+					end = cursor
+				}
+				if next == nil || end.After(nextEnd) {
+					next, nextEnd = c, end
+				}
+			}
+
+			if next == nil || !nextEnd.After(start) {
+				break
+			}
+
+			if nextEnd.Before(cursor) {
+				segments = append(segments, segment{n, nextEnd, cursor})
+			}
+			visit(next, start, nextEnd)
+
+			cursor = next.interval.Start
+			if cursor.Before(start) {
+				cursor = start
+			}
+		}
+
+		if cursor.After(start) {
+			segments = append(segments, segment{n, start, cursor})
+		}
+	}
+	visit(root, root.interval.Start, root.interval.End)
+
+	total := root.duration()
+	index := make(map[*spanNode]int)
+	var steps []CriticalPathStep
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		s := segments[i]
+		j, seen := index[s.node]
+		if !seen {
+			j = len(steps)
+			index[s.node] = j
+			steps = append(steps, CriticalPathStep{
+				File:     traceFile,
+				SpanID:   s.node.trace.Span.ID.Span.String(),
+				Name:     s.node.name,
+				Depth:    s.node.depth,
+				Offset:   s.node.interval.Start.Sub(root.interval.Start),
+				Duration: s.node.duration(),
+			})
+		}
+		steps[j].SelfTime += s.end.Sub(s.start)
+	}
+
+	for i := range steps {
+		if total > 0 {
+			steps[i].Share = float64(steps[i].SelfTime) / float64(total)
+		}
+	}
+
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].Offset < steps[j].Offset
+	})
+
+	return steps, nil
+}
+
+func criticalPathRoot(roots []*spanNode) *spanNode {
+	var best *spanNode
+	for _, r := range roots {
+		if !r.timed {
+			continue
+		}
+		if r.name == "pulumi" {
+			return r
+		}
+		if best == nil || r.duration() > best.duration() {
+			best = r
+		}
+	}
+	return best
+}
+
+// Children with timing information. Spans without timing are skipped
+// and their children are considered instead.
+func timedChildren(n *spanNode) []*spanNode {
+	var children []*spanNode
+	for _, c := range n.children {
+		if c.timed {
+			children = append(children, c)
+		} else {
+			children = append(children, timedChildren(c)...)
+		}
+	}
+	return children
+}
@@ -0,0 +1,39 @@
+package traces
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeCriticalPath(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+
+	steps, err := ComputeCriticalPath(file)
+	require.NoError(t, err)
+
+	type step struct {
+		id   string
+		self time.Duration
+	}
+	var actual []step
+	var total time.Duration
+	for _, s := range steps {
+		actual = append(actual, step{s.SpanID[len(s.SpanID)-1:], s.SelfTime})
+		total += s.SelfTime
+	}
+
+	ms := time.Millisecond
+	assert.Equal(t, []step{
+		{"1", 1500 * ms}, // pulumi, before pulumi-plan and after the checkpoint
+		{"2", 2000 * ms}, // pulumi-plan
+		{"3", 1000 * ms}, // first RegisterResource, until the second one starts
+		{"4", 1500 * ms}, // second RegisterResource
+		{"5", 3500 * ms}, // Create
+		{"7", 500 * ms},  // patchCheckpoint
+	}, actual)
+	assert.Equal(t, 10*time.Second, total)
+	assert.InDelta(t, 0.35, steps[4].Share, 1e-9)
+}
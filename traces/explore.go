@@ -0,0 +1,543 @@
+// A full-screen terminal UI for browsing the span tree of a trace file.
+
+package traces
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+const exploreHelp = "↑/↓ move  ←/→ collapse/expand  E/C expand/collapse all  s sort  / search  n/N next/prev  " +
+	"x slowest  a annotations  q quit"
+
+var (
+	exploreHeaderStyle   = lipgloss.NewStyle().Bold(true)
+	exploreSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	exploreMatchStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("3"))
+	exploreBarStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("4"))
+	exploreStatusStyle   = lipgloss.NewStyle().Faint(true)
+)
+
+// Opens the span tree of the trace file in the terminal until the user
+// quits.
+func Explore(traceFile string) error {
+	traces, err := readTracesFromFile(traceFile)
+	if err != nil {
+		return err
+	}
+
+	m := newExploreModel(traceFile, buildSpanTree(traces))
+	_, err = tea.NewProgram(m, tea.WithAltScreen()).Run()
+	return err
+}
+
+type exploreModel struct {
+	title string
+	roots []*spanNode
+
+	expanded map[*spanNode]bool
+
+	// Children ordered by duration rather than by start time.
+	sortByDuration bool
+
+	// The visible spans, in display order.
+	rows   []*spanNode
+	cursor int
+	offset int
+
+	search  textinput.Model
+	query   string
+	matches []*spanNode
+
+	showAnnotations bool
+	status          string
+
+	width, height int
+}
+
+func newExploreModel(title string, roots []*spanNode) *exploreModel {
+	search := textinput.New()
+	search.Prompt = "/"
+
+	m := &exploreModel{
+		title:    title,
+		roots:    roots,
+		expanded: make(map[*spanNode]bool),
+		search:   search,
+		width:    120,
+		height:   40,
+	}
+	for _, r := range roots {
+		m.expanded[r] = true
+	}
+	m.refresh()
+	return m
+}
+
+func (m *exploreModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *exploreModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.scroll()
+		return m, nil
+	case tea.KeyMsg:
+		if m.search.Focused() {
+			return m, m.updateSearch(msg)
+		}
+		return m, m.updateKey(msg)
+	}
+	return m, nil
+}
+
+func (m *exploreModel) updateSearch(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "enter":
+		m.search.Blur()
+		m.query = m.search.Value()
+		m.nextMatch(1)
+		return nil
+	case "esc":
+		m.search.Blur()
+		return nil
+	}
+	var cmd tea.Cmd
+	m.search, cmd = m.search.Update(msg)
+	return cmd
+}
+
+func (m *exploreModel) updateKey(msg tea.KeyMsg) tea.Cmd {
+	m.status = ""
+	if m.showAnnotations {
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return tea.Quit
+		case "a", "esc", "enter":
+			m.showAnnotations = false
+		}
+		return nil
+	}
+
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return tea.Quit
+	case "up", "k":
+		m.move(-1)
+	case "down", "j":
+		m.move(1)
+	case "pgup":
+		m.move(-m.pageSize())
+	case "pgdown":
+		m.move(m.pageSize())
+	case "home", "g":
+		m.move(-len(m.rows))
+	case "end", "G":
+		m.move(len(m.rows))
+	case "right", "l":
+		if n := m.selected(); n != nil && len(n.children) > 0 && !m.expanded[n] {
+			m.expanded[n] = true
+			m.refresh()
+		}
+	case "enter", " ":
+		if n := m.selected(); n != nil && len(n.children) > 0 {
+			m.expanded[n] = !m.expanded[n]
+			m.refresh()
+		}
+	case "left", "h":
+		n := m.selected()
+		switch {
+		case n == nil:
+		case m.expanded[n] && len(n.children) > 0:
+			m.expanded[n] = false
+			m.refresh()
+		case n.parent != nil:
+			m.selectNode(n.parent)
+		}
+	case "E":
+		if n := m.selected(); n != nil {
+			m.setExpandedBelow(n, true)
+		}
+	case "C":
+		if n := m.selected(); n != nil {
+			m.setExpandedBelow(n, false)
+		}
+	case "s":
+		m.sortByDuration = !m.sortByDuration
+		selected := m.selected()
+		m.refresh()
+		m.selectNode(selected)
+	case "/":
+		m.search.SetValue("")
+		return m.search.Focus()
+	case "n":
+		m.nextMatch(1)
+	case "N":
+		m.nextMatch(-1)
+	case "x":
+		m.jumpToSlowest()
+	case "a":
+		m.showAnnotations = m.selected() != nil
+	}
+	return nil
+}
+
+func (m *exploreModel) selected() *spanNode {
+	if m.cursor < 0 || m.cursor >= len(m.rows) {
+		return nil
+	}
+	return m.rows[m.cursor]
+}
+
+func (m *exploreModel) pageSize() int {
+	// Header, search or status line, and help.
+	if n := m.height - 3; n > 0 {
+		return n
+	}
+	return 1
+}
+
+func (m *exploreModel) move(delta int) {
+	m.cursor += delta
+	if m.cursor >= len(m.rows) {
+		m.cursor = len(m.rows) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	m.scroll()
+}
+
+// Keeps the cursor on screen.
+func (m *exploreModel) scroll() {
+	page := m.pageSize()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+page {
+		m.offset = m.cursor - page + 1
+	}
+}
+
+// The children of a span in display order.
+func (m *exploreModel) children(n *spanNode) []*spanNode {
+	if !m.sortByDuration {
+		return n.children
+	}
+	sorted := append([]*spanNode(nil), n.children...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].duration() > sorted[j].duration()
+	})
+	return sorted
+}
+
+// Recomputes the visible rows after expanding, collapsing or sorting.
+func (m *exploreModel) refresh() {
+	m.rows = m.rows[:0]
+	var add func(nodes []*spanNode)
+	add = func(nodes []*spanNode) {
+		for _, n := range nodes {
+			m.rows = append(m.rows, n)
+			if m.expanded[n] {
+				add(m.children(n))
+			}
+		}
+	}
+	add(m.roots)
+	m.move(0)
+}
+
+// Moves the cursor to the span, expanding its ancestors.
+func (m *exploreModel) selectNode(n *spanNode) {
+	if n == nil {
+		return
+	}
+	for a := n.parent; a != nil; a = a.parent {
+		m.expanded[a] = true
+	}
+	m.refresh()
+	for i, r := range m.rows {
+		if r == n {
+			m.cursor = i
+			break
+		}
+	}
+	m.scroll()
+}
+
+func (m *exploreModel) setExpandedBelow(n *spanNode, expanded bool) {
+	var set func(n *spanNode)
+	set = func(n *spanNode) {
+		m.expanded[n] = expanded
+		for _, c := range n.children {
+			set(c)
+		}
+	}
+	set(n)
+	m.refresh()
+}
+
+// Collects the spans whose names contain the query, ignoring case, in
+// display order.
+func (m *exploreModel) findMatches() {
+	m.matches = nil
+	if m.query == "" {
+		return
+	}
+	query := strings.ToLower(m.query)
+	var find func(nodes []*spanNode)
+	find = func(nodes []*spanNode) {
+		for _, n := range nodes {
+			if strings.Contains(strings.ToLower(n.name), query) {
+				m.matches = append(m.matches, n)
+			}
+			find(m.children(n))
+		}
+	}
+	find(m.roots)
+}
+
+// Selects the match after, or before, the selected one; the first, or
+// last, match if the selected span is not a match. Matches are searched
+// again as expanding and sorting change their order.
+func (m *exploreModel) nextMatch(direction int) {
+	if m.query != "" {
+		m.findMatches()
+	}
+	if len(m.matches) == 0 {
+		if m.query != "" {
+			m.status = fmt.Sprintf("No span matches %q", m.query)
+		}
+		return
+	}
+
+	current := -1
+	for i, n := range m.matches {
+		if n == m.selected() {
+			current = i
+			break
+		}
+	}
+	next := 0
+	switch {
+	case current >= 0:
+		next = (current + direction + len(m.matches)) % len(m.matches)
+	case direction < 0:
+		next = len(m.matches) - 1
+	}
+	m.selectNode(m.matches[next])
+	m.status = fmt.Sprintf("Match %d of %d for %q", next+1, len(m.matches), m.query)
+}
+
+// Selects the descendant of the selected span with the most self time,
+// where the time below the span was actually spent.
+func (m *exploreModel) jumpToSlowest() {
+	n := m.selected()
+	if n == nil {
+		return
+	}
+	var slowest *spanNode
+	var find func(n *spanNode)
+	find = func(n *spanNode) {
+		for _, c := range n.children {
+			if c.timed && (slowest == nil || c.selfTime() > slowest.selfTime()) {
+				slowest = c
+			}
+			find(c)
+		}
+	}
+	find(n)
+	if slowest == nil {
+		m.status = "No timed descendants"
+		return
+	}
+	m.selectNode(slowest)
+	m.status = fmt.Sprintf("Slowest descendant: %s self time", formatShortDuration(slowest.selfTime()))
+}
+
+func (m *exploreModel) View() string {
+	if m.showAnnotations {
+		return m.annotationsView()
+	}
+
+	const durationWidth = 10
+	barWidth := m.width / 4
+	nameWidth := m.width - barWidth - 2*(durationWidth+1) - 1
+	if nameWidth < 10 {
+		nameWidth = 10
+	}
+
+	var b strings.Builder
+	b.WriteString(exploreHeaderStyle.Render(strings.Join([]string{
+		padExplore(truncateExplore(m.title, nameWidth), nameWidth, false),
+		padExplore("duration", durationWidth, true),
+		padExplore("self", durationWidth, true),
+		"timeline",
+	}, " ")))
+	b.WriteString("\n")
+
+	page := m.pageSize()
+	for i := m.offset; i < len(m.rows) && i < m.offset+page; i++ {
+		n := m.rows[i]
+
+		marker := "  "
+		if len(n.children) > 0 {
+			marker = "▸ "
+			if m.expanded[n] {
+				marker = "▾ "
+			}
+		}
+		label := truncateExplore(strings.Repeat("  ", n.depth)+marker+n.name, nameWidth)
+
+		duration, self := "", ""
+		if n.timed {
+			duration = formatShortDuration(n.duration())
+			self = formatShortDuration(n.selfTime())
+		}
+
+		line := strings.Join([]string{
+			padExplore(label, nameWidth, false),
+			padExplore(duration, durationWidth, true),
+			padExplore(self, durationWidth, true),
+			"",
+		}, " ")
+		switch {
+		case i == m.cursor:
+			line = exploreSelectedStyle.Render(line)
+		case m.isMatch(n):
+			line = exploreMatchStyle.Render(line)
+		}
+		b.WriteString(line)
+		b.WriteString(exploreBarStyle.Render(exploreBar(n, barWidth)))
+		b.WriteString("\n")
+	}
+	for i := len(m.rows) - m.offset; i < page; i++ {
+		b.WriteString("\n")
+	}
+
+	switch {
+	case m.search.Focused():
+		b.WriteString(m.search.View())
+	case m.status != "":
+		b.WriteString(m.status)
+	}
+	b.WriteString("\n")
+	b.WriteString(exploreStatusStyle.Render(truncateExplore(exploreHelp, m.width)))
+	return b.String()
+}
+
+func (m *exploreModel) isMatch(n *spanNode) bool {
+	for _, match := range m.matches {
+		if match == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *exploreModel) annotationsView() string {
+	n := m.selected()
+
+	var b strings.Builder
+	b.WriteString(exploreHeaderStyle.Render(n.name))
+	b.WriteString("\n\n")
+
+	anns := n.trace.Span.Annotations
+	keyWidth := 0
+	for _, a := range anns {
+		if len(a.Key) > keyWidth {
+			keyWidth = len(a.Key)
+		}
+	}
+	lines := 0
+	for _, a := range anns {
+		if strings.HasPrefix(a.Key, appdash.SchemaPrefix) {
+			continue
+		}
+		if lines >= m.height-4 {
+			b.WriteString("…\n")
+			break
+		}
+		b.WriteString(truncateExplore(fmt.Sprintf("%-*s  %s", keyWidth, a.Key, string(a.Value)), m.width))
+		b.WriteString("\n")
+		lines++
+	}
+
+	b.WriteString("\n")
+	b.WriteString(exploreStatusStyle.Render("a/esc back  q quit"))
+	return b.String()
+}
+
+// Draws where the span falls within its root span, as a timeline.
+func exploreBar(n *spanNode, width int) string {
+	root := n
+	for root.parent != nil {
+		root = root.parent
+	}
+	if !n.timed || !root.timed || root.duration() <= 0 || width <= 0 {
+		return ""
+	}
+
+	scale := float64(width) / float64(root.duration())
+	start := int(float64(n.interval.Start.Sub(root.interval.Start)) * scale)
+	length := int(float64(n.duration())*scale + 0.5)
+	if start < 0 {
+		start = 0
+	}
+	if start >= width {
+		start = width - 1
+	}
+	if length < 1 {
+		length = 1
+	}
+	if start+length > width {
+		length = width - start
+	}
+	return strings.Repeat(" ", start) + strings.Repeat("█", length)
+}
+
+// Formats a duration with a unit suited to its size, e.g. 1.50s or
+// 12.3ms.
+func formatShortDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	case d >= time.Millisecond:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	}
+}
+
+func truncateExplore(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width <= 1 {
+		return string(runes[:width])
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// Pads to the width in runes, on the left if right-aligned.
+func padExplore(s string, width int, right bool) string {
+	pad := width - utf8.RuneCountInString(s)
+	if pad <= 0 {
+		return s
+	}
+	if right {
+		return strings.Repeat(" ", pad) + s
+	}
+	return s + strings.Repeat(" ", pad)
+}
@@ -0,0 +1,79 @@
+package traces
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func pressKeys(m *exploreModel, keys ...string) {
+	for _, k := range keys {
+		var msg tea.KeyMsg
+		switch k {
+		case "up":
+			msg = tea.KeyMsg{Type: tea.KeyUp}
+		case "down":
+			msg = tea.KeyMsg{Type: tea.KeyDown}
+		case "left":
+			msg = tea.KeyMsg{Type: tea.KeyLeft}
+		case "right":
+			msg = tea.KeyMsg{Type: tea.KeyRight}
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		default:
+			msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+		}
+		m.Update(msg)
+	}
+}
+
+func TestExploreModel(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+	traces, err := readTracesFromFile(file)
+	require.NoError(t, err)
+	m := newExploreModel(file, buildSpanTree(traces))
+
+	// Roots start expanded.
+	require.Len(t, m.rows, 3)
+	assert.Equal(t, "pulumi", m.selected().name)
+
+	pressKeys(m, "down", "right")
+	assert.Equal(t, "pulumi-plan", m.selected().name)
+	assert.Len(t, m.rows, 6)
+
+	// Sorting by duration keeps the selection and puts the longer
+	// RegisterResource first.
+	pressKeys(m, "down")
+	assert.Equal(t, appdash.ID(3), m.selected().trace.Span.ID.Span)
+	pressKeys(m, "s")
+	assert.Equal(t, appdash.ID(3), m.selected().trace.Span.ID.Span)
+	pressKeys(m, "up")
+	assert.Equal(t, appdash.ID(4), m.selected().trace.Span.ID.Span)
+
+	pressKeys(m, "left", "left")
+	assert.Equal(t, "pulumi-plan", m.selected().name)
+	pressKeys(m, "left")
+	assert.Len(t, m.rows, 3)
+
+	// Searching expands the ancestors of the match.
+	pressKeys(m, "/", "c", "r", "e", "a", "t", "e", "enter")
+	assert.Equal(t, "/pulumirpc.ResourceProvider/Create", m.selected().name)
+	assert.Contains(t, m.View(), "Match 1 of 1")
+	pressKeys(m, "/", "r", "e", "g", "i", "s", "t", "e", "r", "enter", "n")
+	assert.Contains(t, m.View(), "Match 2 of 2")
+
+	// The Create span has the most self time below the root.
+	pressKeys(m, "g", "x")
+	assert.Equal(t, "/pulumirpc.ResourceProvider/Create", m.selected().name)
+	assert.Contains(t, m.View(), "3.50s")
+
+	pressKeys(m, "g", "a")
+	view := m.View()
+	assert.Contains(t, view, "os.Args")
+	assert.Contains(t, view, "[pulumi up --yes]")
+	pressKeys(m, "a")
+	assert.Contains(t, m.View(), "timeline")
+}
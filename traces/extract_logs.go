@@ -1,43 +1,315 @@
 package traces
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
 	"sourcegraph.com/sourcegraph/appdash"
 )
 
-func ExtractLogs(inputFilePath string) error {
-	memStore, err := readMemoryStore(inputFilePath)
-	if err != nil {
-		return err
+// Output formats of WriteLogs.
+const (
+	textLogFormat      = "text"
+	jsonLinesLogFormat = "jsonl"
+	csvLogFormat       = csvOutputFormat
+)
+
+// Annotations of the log events of engine log spans.
+const (
+	logMsgAnnotation  = "Msg"
+	logTimeAnnotation = "Time"
+)
+
+// Fields of the request payload of engine log calls, which also carries
+// the URN, stream ID and whether the message is ephemeral.
+const (
+	logSeverityField = "severity"
+	logMessageField  = "message"
+)
+
+// Columns of the log entries written by WriteLogs; the fields of the
+// entries are nested under logEntryFieldsField in JSON and follow the
+// other columns in CSV.
+const (
+	logEntryFileColumn  = "file"
+	logEntryTimeColumn  = "time"
+	logEntryMsgColumn   = "msg"
+	logEntryFieldsField = "fields"
+)
+
+// A message logged by the engine, from a `/pulumirpc.Engine/Log` span.
+type LogEntry struct {
+	File string
+
+	// Zero if the log event has no valid time.
+	Time time.Time
+	Msg  string
+
+	// The time as logged, which may not be valid.
+	RawTime string
+
+	// The other fields of the logged request, such as the severity, URN
+	// and stream ID, and the other annotations of the span without the
+	// appdash event schema.
+	Fields map[string]string
+}
+
+// The severity field of the entry, matching its name case-insensitively.
+func (e *LogEntry) Severity() string {
+	for k, v := range e.Fields {
+		if strings.EqualFold(k, logSeverityField) {
+			return v
+		}
 	}
+	return ""
+}
 
-	traces, err := memStore.Traces(appdash.TracesOpts{})
-	if err != nil {
-		return err
+// The time of the entry in RFC3339 format, or as logged if it is not a
+// valid time.
+func (e *LogEntry) FormatTime() string {
+	if e.Time.IsZero() {
+		return e.RawTime
 	}
+	return formatLogTime(e.Time)
+}
 
-	err = walkTraces(traces, func(tr *appdash.Trace) error {
-		if isEngineLogTrace(tr) {
-			var msg, time string
-			msg = ""
-			time = ""
-			for _, ann := range tr.Annotations {
-				if ann.Key == "Msg" {
-					msg = msg + string(ann.Value)
-				}
-				if ann.Key == "Time" {
-					time = string(ann.Value)
+// Selects log entries; the zero value selects all of them.
+type LogFilter struct {
+	// Severities to keep, compared case-insensitively.
+	Severities []string
+
+	// Keep entries logged at or after Since and before Until, if set.
+	// Entries without a time are dropped by either.
+	Since, Until time.Time
+
+	// Keep entries whose message matches.
+	Msg *regexp.Regexp
+}
+
+func (f *LogFilter) matches(e *LogEntry) bool {
+	if len(f.Severities) > 0 {
+		severity, found := e.Severity(), false
+		for _, s := range f.Severities {
+			if strings.EqualFold(s, severity) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && (e.Time.IsZero() || e.Time.Before(f.Since)) {
+		return false
+	}
+	if !f.Until.IsZero() && (e.Time.IsZero() || !e.Time.Before(f.Until)) {
+		return false
+	}
+	return f.Msg == nil || f.Msg.MatchString(e.Msg)
+}
+
+// Reads the engine log entries of the trace files that pass the filter,
+// sorted by time; entries logged at the same time keep the order of the
+// files, then are ordered by the trace and span IDs of their spans. A
+// span logging several messages yields an entry per message, in order.
+func ReadLogs(inputFilePaths []string, filter LogFilter) ([]LogEntry, error) {
+	type keyedEntry struct {
+		entry LogEntry
+		file  int
+		span  appdash.SpanID
+	}
+
+	var keyed []keyedEntry
+	for i, file := range inputFilePaths {
+		err := streamSpansFromFile(file, func(span *appdash.Span) error {
+			if !isEngineLogSpan(span) {
+				return nil
+			}
+			for _, e := range spanLogEntries(file, span) {
+				if filter.matches(&e) {
+					keyed = append(keyed, keyedEntry{e, i, span.ID})
 				}
 			}
-			fmt.Printf("%s\t%s\t%s\n", inputFilePath, time, msg)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %w", file, err)
+		}
+	}
+
+	// Stable to keep the messages of a span in order.
+	sort.SliceStable(keyed, func(i, j int) bool {
+		a, b := keyed[i], keyed[j]
+		switch {
+		case !a.entry.Time.Equal(b.entry.Time):
+			return a.entry.Time.Before(b.entry.Time)
+		case a.file != b.file:
+			return a.file < b.file
+		case a.span.Trace != b.span.Trace:
+			return a.span.Trace < b.span.Trace
+		default:
+			return a.span.Span < b.span.Span
 		}
-		return nil
 	})
 
+	entries := make([]LogEntry, len(keyed))
+	for i, k := range keyed {
+		entries[i] = k.entry
+	}
+	return entries, nil
+}
+
+// Pairs the Msg and Time annotations of the log events of a span. Pulumi
+// logs the gRPC request and response payloads of the call; the entry is
+// decoded from the request and the response is skipped. Other messages
+// are taken as is.
+func spanLogEntries(file string, span *appdash.Span) []LogEntry {
+	var msgs, times []string
+	fields := make(map[string]string)
+	for _, a := range span.Annotations {
+		switch {
+		case a.Key == logMsgAnnotation:
+			msgs = append(msgs, string(a.Value))
+		case a.Key == logTimeAnnotation:
+			times = append(times, string(a.Value))
+		case strings.HasPrefix(a.Key, appdash.SchemaPrefix):
+		default:
+			fields[a.Key] = string(a.Value)
+		}
+	}
+
+	entries := make([]LogEntry, 0, len(msgs))
+	for i, msg := range msgs {
+		if _, ok := decodeGRPCPayload([]byte(msg), grpcResponsePayload); ok {
+			continue
+		}
+		e := LogEntry{File: file, Msg: msg, Fields: fields}
+		if req, ok := decodeGRPCPayload([]byte(msg), grpcRequestPayload); ok {
+			e.Msg, e.Fields = logRequestEntry(req, fields)
+		}
+		if i < len(times) {
+			e.RawTime = times[i]
+			if t, err := time.Parse(time.RFC3339Nano, times[i]); err == nil {
+				e.Time = t
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Splits the request payload of an engine log call into its message and
+// its other fields, added to the span fields.
+func logRequestEntry(req map[string]interface{}, spanFields map[string]string) (string, map[string]string) {
+	fields := make(map[string]string, len(spanFields)+len(req))
+	for k, v := range spanFields {
+		fields[k] = v
+	}
+
+	var msg string
+	for k, v := range req {
+		switch v := v.(type) {
+		case string:
+			if k == logMessageField {
+				msg = v
+			} else {
+				fields[k] = v
+			}
+		case float64:
+			// The severity enum may be encoded by number.
+			if name, ok := pulumirpc.LogSeverity_name[int32(v)]; ok && k == logSeverityField {
+				fields[k] = name
+			} else {
+				fields[k] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		default:
+			b, err := json.Marshal(v)
+			if err == nil {
+				fields[k] = string(b)
+			}
+		}
+	}
+	return msg, fields
+}
+
+// Writes log entries as tab-separated file, time and message lines
+// (text), JSON objects with the fields nested, one per line (jsonl), or
+// CSV with a column per field.
+func WriteLogs(w io.Writer, entries []LogEntry, format string) error {
+	switch format {
+	case textLogFormat:
+		for _, e := range entries {
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", e.File, e.FormatTime(), e.Msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	case jsonLinesLogFormat:
+		encoder := json.NewEncoder(w)
+		for _, e := range entries {
+			err := encoder.Encode(map[string]interface{}{
+				logEntryFileColumn:  e.File,
+				logEntryTimeColumn:  e.FormatTime(),
+				logEntryMsgColumn:   e.Msg,
+				logEntryFieldsField: e.Fields,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case csvLogFormat:
+		seen := make(map[string]bool)
+		var fields []string
+		records := make([]map[string]interface{}, 0, len(entries))
+		for _, e := range entries {
+			r := make(map[string]interface{}, len(e.Fields)+3)
+			for k, v := range e.Fields {
+				if !seen[k] {
+					seen[k] = true
+					fields = append(fields, k)
+				}
+				r[k] = v
+			}
+			// The entry columns win over fields of the same name.
+			r[logEntryFileColumn] = e.File
+			r[logEntryTimeColumn] = e.FormatTime()
+			r[logEntryMsgColumn] = e.Msg
+			records = append(records, r)
+		}
+		columns := []string{logEntryFileColumn, logEntryTimeColumn, logEntryMsgColumn}
+		for _, f := range orderColumns(fields) {
+			if f != logEntryFileColumn && f != logEntryTimeColumn && f != logEntryMsgColumn {
+				columns = append(columns, f)
+			}
+		}
+		return writeRecords(w, csvOutputFormat, columns, records)
+	default:
+		return fmt.Errorf("Unknown log format %q, expected one of: %s, %s, %s",
+			format, textLogFormat, jsonLinesLogFormat, csvLogFormat)
+	}
+}
+
+func formatLogTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// Prints the engine log messages of a trace file.
+func ExtractLogs(inputFilePath string) error {
+	entries, err := ReadLogs([]string{inputFilePath}, LogFilter{})
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return WriteLogs(os.Stdout, entries, textLogFormat)
 }
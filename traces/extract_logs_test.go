@@ -0,0 +1,144 @@
+package traces
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Writes a trace file with an engine log span per message, logged at the
+// given offsets in seconds from testTraceStart with the payloads Pulumi
+// logs; messages separated by | are logged by the same span. The
+// severity is a JSON value, as the enum may be encoded by name or
+// number.
+func writeTestLogTrace(t *testing.T, name string, logs map[int]string, severity string) string {
+	spans := []testSpan{{id: 1, name: "pulumi", start: 0, end: 60000}}
+	id := uint64(2)
+	for offset, msgs := range logs {
+		span := testSpan{id: id, parent: 1, name: "/pulumirpc.Engine/Log", start: offset * 1000, end: offset*1000 + 1}
+		for _, msg := range strings.Split(msgs, "|") {
+			span.logs = append(span.logs,
+				testGRPCPayload(grpcRequestPayload, `{"severity":`+severity+`,"message":"`+msg+
+					`","urn":"urn:pulumi:dev::p::t::r","streamId":7}`),
+				testGRPCPayload(grpcResponsePayload, `{}`))
+		}
+		spans = append(spans, span)
+		id++
+	}
+	return writeTestTrace(t, name, spans)
+}
+
+func TestReadLogs(t *testing.T) {
+	first := writeTestLogTrace(t, "first.trace", map[int]string{1: "one", 5: "five|five again"}, "1")
+	second := writeTestLogTrace(t, "second.trace", map[int]string{3: "three", 7: "seven"}, `"ERROR"`)
+
+	entries, err := ReadLogs([]string{first, second}, LogFilter{})
+	require.NoError(t, err)
+	var msgs []string
+	for _, e := range entries {
+		msgs = append(msgs, e.Msg)
+	}
+	assert.Equal(t, []string{"one", "three", "five", "five again", "seven"}, msgs)
+	assert.Equal(t, second, entries[1].File)
+	assert.Equal(t, testTraceStart.Add(3*time.Second), entries[1].Time.UTC())
+	assert.Equal(t, "ERROR", entries[1].Severity())
+	assert.Equal(t, "urn:pulumi:dev::p::t::r", entries[1].Fields["urn"])
+	assert.Equal(t, "7", entries[1].Fields["streamId"])
+	assert.Equal(t, "/pulumirpc.Engine/Log", entries[1].Fields["Name"])
+	assert.NotContains(t, entries[1].Fields, "Msg")
+	assert.NotContains(t, entries[1].Fields, "message")
+
+	entries, err = ReadLogs([]string{first, second}, LogFilter{
+		Severities: []string{"error", "warning"},
+		Since:      testTraceStart.Add(3 * time.Second),
+		Until:      testTraceStart.Add(7 * time.Second),
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "three", entries[0].Msg)
+
+	entries, err = ReadLogs([]string{first, second}, LogFilter{Msg: regexp.MustCompile("^five")})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteLogs(&buf, entries, jsonLinesLogFormat))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var rec struct {
+		File   string            `json:"file"`
+		Time   string            `json:"time"`
+		Msg    string            `json:"msg"`
+		Fields map[string]string `json:"fields"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal(t, first, rec.File)
+	assert.Equal(t, "five again", rec.Msg)
+	assert.Equal(t, "INFO", rec.Fields["severity"])
+
+	buf.Reset()
+	require.NoError(t, WriteLogs(&buf, entries, csvLogFormat))
+	header := strings.SplitN(buf.String(), "\n", 2)[0]
+	assert.True(t, strings.HasPrefix(header, "file,time,msg,Name,"), header)
+	assert.Contains(t, header, "severity")
+
+	assert.Error(t, WriteLogs(&buf, entries, "xml"))
+}
+
+func TestReadLogsSameTime(t *testing.T) {
+	memStore := appdash.NewMemoryStore()
+	collectTestSpans(t, memStore, 1, []testSpan{{id: 1, name: "pulumi", start: 0, end: 60000}})
+	var want []string
+	for id := 9; id >= 2; id-- {
+		anns, err := appdash.MarshalEvent(appdash.SpanName("/pulumirpc.Engine/Log"))
+		require.NoError(t, err)
+		msg := fmt.Sprintf("message %d", id)
+		logAnns, err := appdash.MarshalEvent(appdash.LogWithTimestamp(msg, testTraceStart))
+		require.NoError(t, err)
+		require.NoError(t, memStore.Collect(appdash.SpanID{Trace: 1, Span: appdash.ID(id), Parent: 1},
+			append(anns, logAnns...)...))
+		want = append([]string{msg}, want...)
+	}
+	file := filepath.Join(t.TempDir(), "up.trace")
+	require.NoError(t, writeMemoryStore(file, memStore))
+
+	// Ties are ordered by span ID whatever the order of the spans in the
+	// file.
+	entries, err := ReadLogs([]string{file}, LogFilter{})
+	require.NoError(t, err)
+	var msgs []string
+	for _, e := range entries {
+		msgs = append(msgs, e.Msg)
+	}
+	assert.Equal(t, want, msgs)
+}
+
+func TestWriteLogsRawTime(t *testing.T) {
+	memStore := appdash.NewMemoryStore()
+	collectTestSpans(t, memStore, 1, []testSpan{
+		{id: 1, name: "pulumi", start: 0, end: 60000},
+		{id: 2, parent: 1, name: "/pulumirpc.Engine/Log", start: 1000, end: 1001,
+			attrs: map[string]string{"Msg": "odd", "Time": "Jan 1 00:00:01"}},
+	})
+	file := filepath.Join(t.TempDir(), "up.trace")
+	require.NoError(t, writeMemoryStore(file, memStore))
+
+	entries, err := ReadLogs([]string{file}, LogFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].Time.IsZero())
+
+	// A time that does not parse is written as logged.
+	var buf bytes.Buffer
+	require.NoError(t, WriteLogs(&buf, entries, textLogFormat))
+	assert.Equal(t, file+"\tJan 1 00:00:01\todd\n", buf.String())
+}
@@ -0,0 +1,102 @@
+package traces
+
+import (
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Whether Filter keeps or drops the spans matching its query.
+type FilterMode int
+
+const (
+	// Keeps the matching spans with their subtrees, and their ancestors
+	// so that every kept span stays attached to its trace.
+	KeepMatching FilterMode = iota
+
+	// Drops the matching spans with their subtrees.
+	DropMatching
+)
+
+// Writes the spans of the input trace file selected by the query (see
+// ParseSpanQuery) to the output trace file. The output is a valid
+// appdash file: a span is never written without its parent.
+func Filter(inputFilePath, outputFilePath string, query *SpanQuery, mode FilterMode) error {
+	if mode == DropMatching {
+		return filterTraceFile(inputFilePath, outputFilePath, dropSubtrees(query.match))
+	}
+	return filterTraceFile(inputFilePath, outputFilePath, keepSubtrees(query.match))
+}
+
+// Copies the spans of the input file for which keep returns true to the
+// output file, unless outputFilePath is empty.
+func filterTraceFile(inputFilePath, outputFilePath string, keep func(roots []*spanNode) map[*spanNode]bool) error {
+	traces, err := readTracesFromFile(inputFilePath)
+	if err != nil {
+		return err
+	}
+
+	if outputFilePath == "" {
+		return nil
+	}
+
+	roots := buildSpanTree(traces)
+	kept := keep(roots)
+
+	newStore := appdash.NewMemoryStore()
+	err = walkSpanNodes(roots, func(n *spanNode) error {
+		if !kept[n] {
+			return nil
+		}
+		return newStore.Collect(n.trace.ID, n.trace.Annotations...)
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeMemoryStore(outputFilePath, newStore)
+}
+
+func keepSubtrees(match spanPredicate) func(roots []*spanNode) map[*spanNode]bool {
+	return func(roots []*spanNode) map[*spanNode]bool {
+		kept := make(map[*spanNode]bool)
+		var keepAll func(n *spanNode)
+		keepAll = func(n *spanNode) {
+			kept[n] = true
+			for _, c := range n.children {
+				keepAll(c)
+			}
+		}
+		var walk func(nodes []*spanNode)
+		walk = func(nodes []*spanNode) {
+			for _, n := range nodes {
+				if !match(n) {
+					walk(n.children)
+					continue
+				}
+				keepAll(n)
+				for a := n.parent; a != nil && !kept[a]; a = a.parent {
+					kept[a] = true
+				}
+			}
+		}
+		walk(roots)
+		return kept
+	}
+}
+
+func dropSubtrees(match spanPredicate) func(roots []*spanNode) map[*spanNode]bool {
+	return func(roots []*spanNode) map[*spanNode]bool {
+		kept := make(map[*spanNode]bool)
+		var walk func(nodes []*spanNode)
+		walk = func(nodes []*spanNode) {
+			for _, n := range nodes {
+				if match(n) {
+					continue
+				}
+				kept[n] = true
+				walk(n.children)
+			}
+		}
+		walk(roots)
+		return kept
+	}
+}
@@ -0,0 +1,92 @@
+package traces
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func TestSpanQuery(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+	traces, err := readTracesFromFile(file)
+	require.NoError(t, err)
+	roots := buildSpanTree(traces)
+
+	matching := func(source string) []appdash.ID {
+		q, err := ParseSpanQuery(source)
+		require.NoError(t, err)
+		var ids []appdash.ID
+		require.NoError(t, walkSpanNodes(roots, func(n *spanNode) error {
+			if q.match(n) {
+				ids = append(ids, n.trace.Span.ID.Span)
+			}
+			return nil
+		}))
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+
+	assert.Equal(t, []appdash.ID{5}, matching(`name =~ "/pulumirpc.ResourceProvider/.*" && duration > 1s`))
+	assert.Equal(t, []appdash.ID{3, 4}, matching(`name == "/pulumirpc.ResourceMonitor/RegisterResource"`))
+	assert.Equal(t, []appdash.ID{4}, matching(`child(name =~ "Provider") || duration >= 1m`))
+	assert.Equal(t, []appdash.ID{5, 6}, matching(`ancestor(name == "pulumi-plan") && !parent(name == "pulumi-plan") || has("Msg")`))
+	assert.Equal(t, []appdash.ID{1, 2, 3, 7}, matching(`(depth <= 1 || self > 2.5s) && name !~ "Provider"`))
+	assert.Equal(t, []appdash.ID{7}, matching(`ann["api"] == "https://api.pulumi.com"`))
+	assert.Equal(t, []appdash.ID{1, 2, 4}, matching(`descendant(name =~ "Create") || children > 1 && depth == 0`))
+
+	for _, invalid := range []string{
+		`name`,
+		`name == `,
+		`name =~ "("`,
+		`duration > 5`,
+		`depth > "x"`,
+		`unknown == "x"`,
+		`(name == "x"`,
+		`name == "x" extra`,
+		`ann[1] == "x"`,
+		`name == "unterminated`,
+	} {
+		_, err := ParseSpanQuery(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func filteredSpanIDs(t *testing.T, file string) []appdash.ID {
+	var ids []appdash.ID
+	require.NoError(t, walkTracesFromFile(file, func(tr *appdash.Trace) error {
+		ids = append(ids, tr.Span.ID.Span)
+		return nil
+	}))
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestFilter(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+	dir := t.TempDir()
+
+	q, err := ParseSpanQuery(`name == "/pulumirpc.ResourceMonitor/RegisterResource" && duration > 4s`)
+	require.NoError(t, err)
+
+	// The matching span keeps its subtree and its ancestors.
+	kept := filepath.Join(dir, "kept.trace")
+	require.NoError(t, Filter(file, kept, q, KeepMatching))
+	assert.Equal(t, []appdash.ID{1, 2, 4, 5}, filteredSpanIDs(t, kept))
+
+	traces, err := readTracesFromFile(kept)
+	require.NoError(t, err)
+	require.Len(t, traces, 1)
+	assert.Equal(t, "pulumi", traces[0].Span.Name())
+
+	dropped := filepath.Join(dir, "dropped.trace")
+	require.NoError(t, Filter(file, dropped, q, DropMatching))
+	assert.Equal(t, []appdash.ID{1, 2, 3, 6, 7}, filteredSpanIDs(t, dropped))
+
+	noLogs := filepath.Join(dir, "nologs.trace")
+	require.NoError(t, RemoveLogs(file, noLogs))
+	assert.Equal(t, []appdash.ID{1, 2, 3, 4, 5, 7}, filteredSpanIDs(t, noLogs))
+}
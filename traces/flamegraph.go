@@ -0,0 +1,134 @@
+package traces
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Weights of the stacks written by FlameGraph.
+const (
+	SelfFlameGraphWeight  = "self"
+	TotalFlameGraphWeight = "total"
+	CountFlameGraphWeight = "count"
+)
+
+type FlameGraphOptions struct {
+	// One of self (time spent in the span itself, the default), total
+	// (span duration) or count. Times are in microseconds.
+	Weight string
+
+	// Replaces IDs in span names, such as UUIDs, long hex strings and
+	// numeric path segments, so that spans differing only by ID fold
+	// into the same frame.
+	CollapseIDs bool
+
+	// Adds the trace file name as the bottom frame of every stack, to
+	// tell the files apart in the merged profile.
+	FileFrames bool
+}
+
+var (
+	uuidPattern   = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	hexPattern    = regexp.MustCompile(`(?i)\b[0-9a-f]{8,}\b`)
+	numberPattern = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// Replaces UUIDs, hex strings of 8 or more digits that are not plain
+// words, and numeric path segments in a span name.
+func collapseSpanIDs(name string) string {
+	name = uuidPattern.ReplaceAllString(name, "{uuid}")
+	name = hexPattern.ReplaceAllStringFunc(name, func(s string) string {
+		if strings.ContainsAny(s, "0123456789") {
+			return "{hex}"
+		}
+		return s
+	})
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		if numberPattern.MatchString(s) {
+			segments[i] = "{n}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Writes the span trees of the trace files as folded stacks, one
+// `root;child;span weight` line per distinct stack, as read by
+// flamegraph.pl and speedscope. All files merge into one profile.
+func FlameGraph(traceFiles []string, w io.Writer, opts FlameGraphOptions) error {
+	weight, err := flameGraphWeight(opts.Weight)
+	if err != nil {
+		return err
+	}
+
+	stacks := make(map[string]int64)
+	for _, file := range traceFiles {
+		traces, err := readTracesFromFile(file)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %w", file, err)
+		}
+
+		var prefix []string
+		if opts.FileFrames {
+			prefix = append(prefix, flameGraphFrame(filepath.Base(file), false))
+		}
+
+		var walk func(nodes []*spanNode, stack []string)
+		walk = func(nodes []*spanNode, stack []string) {
+			for _, n := range nodes {
+				s := append(stack[:len(stack):len(stack)], flameGraphFrame(n.name, opts.CollapseIDs))
+				if v := weight(n); v > 0 {
+					stacks[strings.Join(s, ";")] += v
+				}
+				walk(n.children, s)
+			}
+		}
+		walk(buildSpanTree(traces), prefix)
+	}
+
+	keys := make([]string, 0, len(stacks))
+	for k := range stacks {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := bufio.NewWriter(w)
+	for _, k := range keys {
+		if _, err := fmt.Fprintf(out, "%s %d\n", k, stacks[k]); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+func flameGraphWeight(weight string) (func(n *spanNode) int64, error) {
+	switch weight {
+	case SelfFlameGraphWeight, "":
+		return func(n *spanNode) int64 { return int64(n.selfTime() / time.Microsecond) }, nil
+	case TotalFlameGraphWeight:
+		return func(n *spanNode) int64 { return int64(n.duration() / time.Microsecond) }, nil
+	case CountFlameGraphWeight:
+		return func(n *spanNode) int64 { return 1 }, nil
+	default:
+		return nil, fmt.Errorf("Unknown flame graph weight %q, expected one of: %s, %s, %s",
+			weight, SelfFlameGraphWeight, TotalFlameGraphWeight, CountFlameGraphWeight)
+	}
+}
+
+// Makes a span name safe as a frame: semicolons separate frames and
+// line breaks separate stacks.
+func flameGraphFrame(name string, collapseIDs bool) string {
+	if collapseIDs {
+		name = collapseSpanIDs(name)
+	}
+	if name == "" {
+		name = "unknown"
+	}
+	return strings.NewReplacer(";", ":", "\n", " ", "\r", " ").Replace(name)
+}
@@ -0,0 +1,50 @@
+package traces
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlameGraph(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+
+	var buf bytes.Buffer
+	require.NoError(t, FlameGraph([]string{file}, &buf, FlameGraphOptions{}))
+	assert.Equal(t, `pulumi 1500000
+pulumi;api/patchCheckpoint 500000
+pulumi;pulumi-plan 2000000
+pulumi;pulumi-plan;/pulumirpc.Engine/Log 1000
+pulumi;pulumi-plan;/pulumirpc.ResourceMonitor/RegisterResource 4500000
+pulumi;pulumi-plan;/pulumirpc.ResourceMonitor/RegisterResource;/pulumirpc.ResourceProvider/Create 3500000
+`, buf.String())
+
+	// Merging a file with itself doubles the counts.
+	buf.Reset()
+	require.NoError(t, FlameGraph([]string{file, file}, &buf, FlameGraphOptions{
+		Weight:     CountFlameGraphWeight,
+		FileFrames: true,
+	}))
+	assert.Contains(t, buf.String(), "up.trace;pulumi;pulumi-plan;/pulumirpc.ResourceMonitor/RegisterResource 4\n")
+
+	buf.Reset()
+	require.NoError(t, FlameGraph([]string{file}, &buf, FlameGraphOptions{Weight: TotalFlameGraphWeight}))
+	assert.Contains(t, buf.String(), "pulumi;pulumi-plan 8000000\n")
+
+	assert.Error(t, FlameGraph([]string{file}, &buf, FlameGraphOptions{Weight: "wall"}))
+}
+
+func TestCollapseSpanIDs(t *testing.T) {
+	for name, expected := range map[string]string{
+		"api/stacks/acme/web/prod/update/3f2b8c1e-4d5a-4b6c-8d7e-9f0a1b2c3d4e": "api/stacks/acme/web/prod/update/{uuid}",
+		"api/updates/42/events":                  "api/updates/{n}/events",
+		"checkpoint 5f3e2a1b9c8d":                "checkpoint {hex}",
+		"pulumi-resource-aws-v6.1.0":             "pulumi-resource-aws-v6.1.0",
+		"/pulumirpc.ResourceProvider/deadbeefed": "/pulumirpc.ResourceProvider/deadbeefed",
+	} {
+		assert.Equal(t, expected, collapseSpanIDs(name), name)
+	}
+	assert.Equal(t, "a:b", flameGraphFrame("a;b", false))
+}
@@ -0,0 +1,170 @@
+package traces
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-trace-tool/intervals"
+	"github.com/pulumi/pulumi-trace-tool/stats"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Prefix of the names of the spans of Pulumi gRPC calls, such as
+// `/pulumirpc.ResourceMonitor/RegisterResource`.
+const grpcSpanNamePrefix = "/pulumirpc."
+
+// File column of the rows aggregated across all the trace files.
+const allFilesLatencyRow = "(all)"
+
+type LatencyOptions struct {
+	// Only reports the spans of gRPC methods.
+	GRPCOnly bool
+
+	// Replaces IDs in span names (see FlameGraphOptions) so that spans
+	// differing only by ID are reported together.
+	CollapseIDs bool
+
+	// Also reports the union time of the spans of every name in each
+	// file, which holds the intervals of the file's spans in memory.
+	Union bool
+}
+
+// Latency statistics of the spans of a name.
+type latencyStats struct {
+	durations *stats.Histogram
+
+	// Only set per file with LatencyOptions.Union, as files may not
+	// share a timeline.
+	union    time.Duration
+	hasUnion bool
+}
+
+// Writes the count, total, mean, p50, p90, p99 and maximum duration of
+// the spans of every name, per trace file and, given several files,
+// across all of them, as a table, CSV or JSON (see writeRecords). With
+// LatencyOptions.Union, the rows of each file also hold the union time
+// of the spans; the rows across files leave it empty. Rows are ordered
+// by total time. Percentiles are estimated within
+// stats.DefaultHistogramAccuracy.
+func Latency(traceFiles []string, format string, opts LatencyOptions, w io.Writer) error {
+	if err := checkOutputFormat(format); err != nil {
+		return err
+	}
+
+	var records []map[string]interface{}
+	all := make(map[string]*latencyStats)
+	for _, file := range traceFiles {
+		byName, err := fileLatencyStats(file, opts)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %w", file, err)
+		}
+		records = append(records, latencyRecords(file, byName)...)
+
+		for name, s := range byName {
+			acc, ok := all[name]
+			if !ok {
+				acc = &latencyStats{durations: stats.NewHistogram(0)}
+				all[name] = acc
+			}
+			if err := acc.durations.Merge(s.durations); err != nil {
+				return err
+			}
+		}
+	}
+	if len(traceFiles) > 1 {
+		records = append(records, latencyRecords(allFilesLatencyRow, all)...)
+	}
+
+	columns := []string{
+		"file", "name", "count", "total_ms", "mean_ms",
+		"p50_ms", "p90_ms", "p99_ms", "max_ms",
+	}
+	if opts.Union {
+		columns = append(columns, "union_ms")
+	}
+	return writeRecords(w, format, columns, records)
+}
+
+// Streams the spans of a file into latency statistics by span name,
+// skipping spans without valid times.
+func fileLatencyStats(file string, opts LatencyOptions) (map[string]*latencyStats, error) {
+	byName := make(map[string]*latencyStats)
+	spans := make(map[string][]intervals.Interval)
+	err := streamSpansFromFile(file, func(span *appdash.Span) error {
+		name := span.Name()
+		if opts.GRPCOnly && !strings.HasPrefix(name, grpcSpanNamePrefix) {
+			return nil
+		}
+		if opts.CollapseIDs {
+			name = collapseSpanIDs(name)
+		}
+
+		iv, err := spanInterval(span.Annotations.StringMap())
+		if err != nil || iv.End.Before(iv.Start) {
+			return nil
+		}
+
+		s, ok := byName[name]
+		if !ok {
+			s = &latencyStats{durations: stats.NewHistogram(0)}
+			byName[name] = s
+		}
+		s.durations.Add(float64(iv.End.Sub(iv.Start)))
+		if opts.Union {
+			spans[name] = append(spans[name], iv)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, ivs := range spans {
+		byName[name].union, byName[name].hasUnion = intervals.UnionTime(ivs), true
+	}
+	return byName, nil
+}
+
+func latencyRecords(file string, byName map[string]*latencyStats) []map[string]interface{} {
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ti, tj := byName[names[i]].durations.Sum(), byName[names[j]].durations.Sum()
+		if ti != tj {
+			return ti > tj
+		}
+		return names[i] < names[j]
+	})
+
+	// Estimates are rounded to the microsecond to keep the output short.
+	roundMs := func(nanos float64) float64 {
+		return msFloat(time.Duration(nanos).Round(time.Microsecond))
+	}
+
+	records := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		s := byName[name]
+		h := s.durations
+		r := map[string]interface{}{
+			"file":     file,
+			"name":     name,
+			"count":    h.Count(),
+			"total_ms": roundMs(h.Sum()),
+			"mean_ms":  roundMs(h.Mean()),
+			"p50_ms":   roundMs(h.Quantile(0.5)),
+			"p90_ms":   roundMs(h.Quantile(0.9)),
+			"p99_ms":   roundMs(h.Quantile(0.99)),
+			"max_ms":   roundMs(h.Max()),
+		}
+		if s.hasUnion {
+			r["union_ms"] = msFloat(s.union)
+		}
+		records = append(records, r)
+	}
+	return records
+}
@@ -0,0 +1,54 @@
+package traces
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatency(t *testing.T) {
+	a := writeTestTrace(t, "a.trace", testPulumiSpans())
+	b := writeTestTrace(t, "b.trace", testPulumiSpans())
+
+	var buf bytes.Buffer
+	require.NoError(t, Latency([]string{a, b}, jsonOutputFormat, LatencyOptions{GRPCOnly: true, Union: true}, &buf))
+	var rows []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rows))
+	require.Len(t, rows, 9)
+
+	// Ordered by total time within each file.
+	var names []interface{}
+	for _, r := range rows[:3] {
+		assert.Equal(t, a, r["file"])
+		names = append(names, r["name"])
+	}
+	assert.Equal(t, []interface{}{
+		"/pulumirpc.ResourceMonitor/RegisterResource",
+		"/pulumirpc.ResourceProvider/Create",
+		"/pulumirpc.Engine/Log",
+	}, names)
+
+	register := rows[0]
+	assert.Equal(t, 2.0, register["count"])
+	assert.Equal(t, 8000.0, register["total_ms"])
+	assert.Equal(t, 4000.0, register["mean_ms"])
+	assert.InEpsilon(t, 3000.0, register["p50_ms"], 0.01)
+	assert.Equal(t, 5000.0, register["max_ms"])
+	assert.Equal(t, 6000.0, register["union_ms"], "overlapping calls count once")
+
+	all := rows[6]
+	assert.Equal(t, allFilesLatencyRow, all["file"])
+	assert.Equal(t, "/pulumirpc.ResourceMonitor/RegisterResource", all["name"])
+	assert.Equal(t, 4.0, all["count"])
+	assert.Equal(t, 16000.0, all["total_ms"])
+	assert.InEpsilon(t, 5000.0, all["p90_ms"], 0.01)
+	assert.NotContains(t, all, "union_ms", "files may not share a timeline")
+
+	buf.Reset()
+	require.NoError(t, Latency([]string{a}, csvOutputFormat, LatencyOptions{}, &buf))
+	assert.Contains(t, buf.String(), "file,name,count,total_ms,mean_ms,p50_ms,p90_ms,p99_ms,max_ms\n")
+	assert.Contains(t, buf.String(), ",pulumi,1,10000,10000,")
+}
@@ -0,0 +1,328 @@
+package traces
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-trace-tool/stats"
+)
+
+// Prefix of the Go runtime memory statistics annotations.
+const memStatsPrefix = "MemStats."
+
+// Columns of the memory samples, see MemSample. The _max columns hold
+// the running maximum Pulumi records over its polls, rather than the
+// value at the time of the sample.
+const (
+	heapAllocMemColumn    = "heap_alloc"
+	heapAllocMaxMemColumn = "heap_alloc_max"
+	heapInuseMemColumn    = "heap_inuse"
+	heapInuseMaxMemColumn = "heap_inuse_max"
+	sysMemColumn          = "sys"
+	sysMaxMemColumn       = "sys_max"
+	numGCMemColumn        = "num_gc"
+	pauseTotalNsMemColumn = "gc_pause_total_ns"
+)
+
+// Annotation read into each column of the memory samples.
+var memSampleAnnotations = []struct {
+	column     string
+	annotation string
+}{
+	{heapAllocMemColumn, memStatsPrefix + "HeapAlloc"},
+	{heapAllocMaxMemColumn, memStatsPrefix + "HeapAlloc.Max"},
+	{heapInuseMemColumn, memStatsPrefix + "HeapInuse"},
+	{heapInuseMaxMemColumn, memStatsPrefix + "HeapInuse.Max"},
+	{sysMemColumn, memStatsPrefix + "Sys"},
+	{sysMaxMemColumn, memStatsPrefix + "Sys.Max"},
+	{numGCMemColumn, memStatsPrefix + "NumGC"},
+	{pauseTotalNsMemColumn, memStatsPrefix + "PauseTotalNs"},
+}
+
+// Deepest level of the spans reported as the phase of the memory peak,
+// e.g. `pulumi;pulumi-plan;/pulumirpc.LanguageRuntime/Run`.
+const memPeakPhaseDepth = 2
+
+// A sample of the Go runtime memory statistics of a Pulumi process, as
+// recorded with PULUMI_TRACING_MEMSTATS_POLL_INTERVAL.
+type MemSample struct {
+	File    string
+	Process string
+
+	// End of the span carrying the statistics. Pulumi sets them when
+	// the span ends and does not record when each poll happened, so
+	// per-poll times are not available.
+	Time time.Time
+
+	// Values by column, e.g. heap_alloc; missing if not recorded.
+	Values map[string]int64
+}
+
+// Statistics derived from the memory samples of a process.
+type MemStatsSummary struct {
+	File    string
+	Process string
+	Samples int
+
+	// Change of the heap allocation per second between the first and
+	// the last sample; NaN with fewer than two samples.
+	HeapAllocGrowth float64
+
+	// Largest heap allocation sampled, zero if none was.
+	PeakHeapAlloc int64
+	PeakTime      time.Time
+
+	// Time of the peak from the start of the trace, and the spans
+	// running at that point, outermost first.
+	PeakOffset time.Duration
+	PeakPhase  string
+
+	// Largest running maximum of the heap allocation recorded; its
+	// time is unknown.
+	HeapAllocMax int64
+
+	// Percentiles of the mean GC pause between consecutive samples;
+	// zero with fewer than two samples.
+	GCPauseP50, GCPauseP90, GCPauseP99 time.Duration
+}
+
+// Reads the memory samples of a trace file, ordered by time: one per
+// span carrying any of the statistics, with the last value of each.
+//
+// Pulumi sets the statistics as tags of the root span of every process,
+// so a trace usually holds one sample per process, at the end of its
+// root span. Samples over time need spans recording them as they go,
+// such as a span per poll.
+func ReadMemSamples(traceFile string) ([]MemSample, error) {
+	traces, err := readTracesFromFile(traceFile)
+	if err != nil {
+		return nil, err
+	}
+	return memSamples(traceFile, buildSpanTree(traces)), nil
+}
+
+func memSamples(file string, roots []*spanNode) []MemSample {
+	var samples []MemSample
+	var walk func(nodes []*spanNode, parentProcess string)
+	walk = func(nodes []*spanNode, parentProcess string) {
+		for _, n := range nodes {
+			process := spanProcessName(n.trace, parentProcess)
+			if n.timed {
+				samples = append(samples, spanMemSamples(file, process, n)...)
+			}
+			walk(n.children, process)
+		}
+	}
+	walk(roots, "")
+
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples
+}
+
+func spanMemSamples(file, process string, n *spanNode) []MemSample {
+	values := make(map[string]int64)
+	for _, c := range memSampleAnnotations {
+		for _, a := range n.trace.Span.Annotations {
+			if a.Key != c.annotation {
+				continue
+			}
+			if v, err := strconv.ParseInt(string(a.Value), 10, 64); err == nil {
+				values[c.column] = v
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return []MemSample{{File: file, Process: process, Time: n.interval.End, Values: values}}
+}
+
+// Summarizes the memory samples of every process of the trace file.
+func SummarizeMemStats(traceFile string) ([]MemStatsSummary, error) {
+	traces, err := readTracesFromFile(traceFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := buildSpanTree(traces)
+	samples := memSamples(traceFile, roots)
+
+	var processes []string
+	byProcess := make(map[string][]MemSample)
+	for _, s := range samples {
+		if _, ok := byProcess[s.Process]; !ok {
+			processes = append(processes, s.Process)
+		}
+		byProcess[s.Process] = append(byProcess[s.Process], s)
+	}
+
+	var start time.Time
+	for _, r := range roots {
+		if r.timed && (start.IsZero() || r.interval.Start.Before(start)) {
+			start = r.interval.Start
+		}
+	}
+
+	var res []MemStatsSummary
+	for _, p := range processes {
+		res = append(res, summarizeMemSamples(traceFile, p, byProcess[p], roots, start))
+	}
+	return res, nil
+}
+
+func summarizeMemSamples(file, process string, samples []MemSample, roots []*spanNode, start time.Time) MemStatsSummary {
+	s := MemStatsSummary{File: file, Process: process, Samples: len(samples), HeapAllocGrowth: math.NaN()}
+
+	var withHeap []MemSample
+	for _, m := range samples {
+		if v, ok := m.Values[heapAllocMemColumn]; ok {
+			withHeap = append(withHeap, m)
+			if v > s.PeakHeapAlloc || s.PeakTime.IsZero() {
+				s.PeakHeapAlloc, s.PeakTime = v, m.Time
+			}
+		}
+		if v := m.Values[heapAllocMaxMemColumn]; v > s.HeapAllocMax {
+			s.HeapAllocMax = v
+		}
+	}
+	if n := len(withHeap); n >= 2 {
+		first, last := withHeap[0], withHeap[n-1]
+		if elapsed := last.Time.Sub(first.Time); elapsed > 0 {
+			delta := float64(last.Values[heapAllocMemColumn] - first.Values[heapAllocMemColumn])
+			s.HeapAllocGrowth = delta / elapsed.Seconds()
+		}
+	}
+	if !s.PeakTime.IsZero() {
+		s.PeakOffset = s.PeakTime.Sub(start)
+		s.PeakPhase = memPeakPhase(roots, s.PeakTime)
+	}
+
+	// The totals before the first sample are cumulative over the run of
+	// the process so far, so the first sample only sets the baseline.
+	var pauses []float64
+	var prev *MemSample
+	for i := range samples {
+		m := &samples[i]
+		gcs, ok1 := m.Values[numGCMemColumn]
+		pause, ok2 := m.Values[pauseTotalNsMemColumn]
+		if !ok1 || !ok2 {
+			continue
+		}
+		if prev != nil {
+			prevGCs, prevPause := prev.Values[numGCMemColumn], prev.Values[pauseTotalNsMemColumn]
+			if gcs > prevGCs {
+				pauses = append(pauses, float64(pause-prevPause)/float64(gcs-prevGCs))
+			}
+		}
+		prev = m
+	}
+	if len(pauses) > 0 {
+		s.GCPauseP50 = time.Duration(stats.Quantile(pauses, 0.5))
+		s.GCPauseP90 = time.Duration(stats.Quantile(pauses, 0.9))
+		s.GCPauseP99 = time.Duration(stats.Quantile(pauses, 0.99))
+	}
+	return s
+}
+
+// Names the spans down to memPeakPhaseDepth running at the time, taking
+// the earliest started span at each level.
+func memPeakPhase(roots []*spanNode, at time.Time) string {
+	covers := func(n *spanNode) bool {
+		return n.timed && !at.Before(n.interval.Start) && !at.After(n.interval.End)
+	}
+
+	var names []string
+	nodes := roots
+	for depth := 0; depth <= memPeakPhaseDepth; depth++ {
+		var next *spanNode
+		for _, n := range nodes {
+			if covers(n) {
+				next = n
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		names = append(names, next.name)
+		nodes = next.children
+	}
+	return strings.Join(names, ";")
+}
+
+// Writes the memory samples of the trace files as a table, CSV or JSON
+// (see writeRecords), or with summary set, their derived statistics.
+func MemStats(traceFiles []string, format string, summary bool, w io.Writer) error {
+	if err := checkOutputFormat(format); err != nil {
+		return err
+	}
+
+	if summary {
+		return writeMemStatsSummaries(traceFiles, format, w)
+	}
+
+	var records []map[string]interface{}
+	for _, f := range traceFiles {
+		samples, err := ReadMemSamples(f)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %w", f, err)
+		}
+		for _, s := range samples {
+			r := map[string]interface{}{
+				"file":     s.File,
+				"process":  s.Process,
+				"span_end": s.Time,
+			}
+			for column, v := range s.Values {
+				r[column] = v
+			}
+			records = append(records, r)
+		}
+	}
+
+	columns := []string{"file", "process", "span_end"}
+	for _, c := range memSampleAnnotations {
+		columns = append(columns, c.column)
+	}
+	return writeRecords(w, format, columns, records)
+}
+
+func writeMemStatsSummaries(traceFiles []string, format string, w io.Writer) error {
+	var records []map[string]interface{}
+	for _, f := range traceFiles {
+		summaries, err := SummarizeMemStats(f)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %w", f, err)
+		}
+		for _, s := range summaries {
+			r := map[string]interface{}{
+				"file":            s.File,
+				"process":         s.Process,
+				"samples":         s.Samples,
+				"heap_alloc_max":  s.HeapAllocMax,
+				"gc_pause_p50_ms": msFloat(s.GCPauseP50),
+				"gc_pause_p90_ms": msFloat(s.GCPauseP90),
+				"gc_pause_p99_ms": msFloat(s.GCPauseP99),
+			}
+			if !math.IsNaN(s.HeapAllocGrowth) {
+				r["heap_alloc_growth_bytes_per_s"] = math.Round(s.HeapAllocGrowth)
+			}
+			if !s.PeakTime.IsZero() {
+				r["peak_heap_alloc"] = s.PeakHeapAlloc
+				r["peak_offset_ms"] = msFloat(s.PeakOffset)
+				r["peak_phase"] = s.PeakPhase
+			}
+			records = append(records, r)
+		}
+	}
+
+	columns := []string{
+		"file", "process", "samples", "heap_alloc_growth_bytes_per_s", "peak_heap_alloc",
+		"peak_offset_ms", "peak_phase", "heap_alloc_max", "gc_pause_p50_ms", "gc_pause_p90_ms", "gc_pause_p99_ms",
+	}
+	return writeRecords(w, format, columns, records)
+}
@@ -0,0 +1,92 @@
+package traces
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func TestMemStats(t *testing.T) {
+	spans := testPulumiSpans()
+	spans[2].attrs = map[string]string{
+		"MemStats.HeapAlloc": "1000", "MemStats.NumGC": "1", "MemStats.PauseTotalNs": "100",
+	}
+	spans[3].attrs = map[string]string{
+		"MemStats.HeapAlloc": "5000", "MemStats.NumGC": "3", "MemStats.PauseTotalNs": "500",
+	}
+	spans = append(spans, testSpan{
+		id: 8, parent: 5, name: "pulumi-resource-aws", start: 4000, end: 7000,
+		attrs: map[string]string{"MemStats.HeapAlloc.Max": "700"},
+	})
+	memStore := appdash.NewMemoryStore()
+	collectTestSpans(t, memStore, 1, spans)
+
+	// The root span carries the running maximum, and the heap
+	// allocation twice, of which the last value counts.
+	require.NoError(t, memStore.Collect(appdash.SpanID{Trace: 1, Span: 1},
+		appdash.Annotation{Key: "MemStats.HeapAlloc", Value: []byte("2000")},
+		appdash.Annotation{Key: "MemStats.HeapAlloc", Value: []byte("3000")},
+		appdash.Annotation{Key: "MemStats.HeapAlloc.Max", Value: []byte("6000")},
+		appdash.Annotation{Key: "MemStats.NumGC", Value: []byte("4")},
+		appdash.Annotation{Key: "MemStats.PauseTotalNs", Value: []byte("1100")}))
+	file := filepath.Join(t.TempDir(), "up.trace")
+	require.NoError(t, writeMemoryStore(file, memStore))
+
+	samples, err := ReadMemSamples(file)
+	require.NoError(t, err)
+	require.Len(t, samples, 4)
+
+	// Samples are at the end of their spans.
+	assert.Equal(t, testTraceStart.Add(5*time.Second), samples[0].Time.UTC())
+	assert.Equal(t, map[string]int64{heapAllocMemColumn: 1000, numGCMemColumn: 1, pauseTotalNsMemColumn: 100},
+		samples[0].Values)
+	assert.Equal(t, "pulumi-resource-aws", samples[1].Process)
+	assert.Equal(t, map[string]int64{heapAllocMaxMemColumn: 700}, samples[1].Values)
+	assert.Equal(t, testTraceStart.Add(10*time.Second), samples[3].Time.UTC())
+	assert.Equal(t, int64(3000), samples[3].Values[heapAllocMemColumn])
+	assert.Equal(t, int64(6000), samples[3].Values[heapAllocMaxMemColumn])
+
+	summaries, err := SummarizeMemStats(file)
+	require.NoError(t, err)
+	require.Len(t, summaries, 2)
+
+	s := summaries[0]
+	assert.Equal(t, "pulumi", s.Process)
+	assert.Equal(t, 3, s.Samples)
+	assert.InDelta(t, 400, s.HeapAllocGrowth, 0.01)
+	assert.Equal(t, int64(5000), s.PeakHeapAlloc)
+	assert.Equal(t, 8*time.Second, s.PeakOffset)
+	assert.Equal(t, "pulumi;pulumi-plan;/pulumirpc.ResourceMonitor/RegisterResource", s.PeakPhase)
+	assert.Equal(t, int64(6000), s.HeapAllocMax)
+
+	// Mean pauses of 200ns then 600ns; the totals up to the first
+	// sample do not count.
+	assert.Equal(t, 400*time.Nanosecond, s.GCPauseP50)
+	assert.Equal(t, 596*time.Nanosecond, s.GCPauseP99)
+
+	aws := summaries[1]
+	assert.True(t, math.IsNaN(aws.HeapAllocGrowth), "NaN with one sample")
+	assert.True(t, aws.PeakTime.IsZero())
+	assert.Equal(t, int64(700), aws.HeapAllocMax)
+
+	var buf bytes.Buffer
+	require.NoError(t, MemStats([]string{file}, csvOutputFormat, false, &buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, "file,process,span_end,heap_alloc,heap_alloc_max,heap_inuse,heap_inuse_max,"+
+		"sys,sys_max,num_gc,gc_pause_total_ns", lines[0])
+
+	buf.Reset()
+	require.NoError(t, MemStats([]string{file}, csvOutputFormat, true, &buf))
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[1], ",pulumi,3,400,5000,8000,")
+	assert.Contains(t, lines[2], ",pulumi-resource-aws,1,,,,,700,")
+}
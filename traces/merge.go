@@ -0,0 +1,212 @@
+package traces
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Annotation naming the input file of the synthetic spans added by
+// Merge.
+const mergeFileAnnotation = "trace_file"
+
+// Name of the synthetic root span added by Merge.
+const mergeRootName = "merge"
+
+type MergeOptions struct {
+	// Puts the spans of every input under a synthetic span named after
+	// the file, and those under a single root span, so that the inputs
+	// form one trace.
+	Reroot bool
+
+	// Gives the spans of an input fresh IDs when they collide with the
+	// spans of an earlier input, instead of failing.
+	RenameCollisions bool
+}
+
+// Identifies a span across inputs; the parent does not matter.
+type mergeSpanKey struct {
+	trace, span appdash.ID
+}
+
+// Combines the spans of the trace files into a single appdash file.
+func Merge(inputFiles []string, outputFile string, opts MergeOptions) error {
+	newStore := appdash.NewMemoryStore()
+	seen := make(map[mergeSpanKey]string)
+
+	var root appdash.SpanID
+	if opts.Reroot {
+		root = appdash.NewRootSpanID()
+		seen[mergeSpanKey{root.Trace, root.Span}] = mergeRootName
+		if err := collectSyntheticSpan(newStore, root, mergeRootName, ""); err != nil {
+			return err
+		}
+	}
+
+	var start, end time.Time
+	for _, file := range inputFiles {
+		traces, err := readTracesFromFile(file)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %w", file, err)
+		}
+
+		var fileSpan appdash.SpanID
+		if opts.Reroot {
+			fileSpan = appdash.NewSpanID(root)
+			seen[mergeSpanKey{fileSpan.Trace, fileSpan.Span}] = file
+			if err := collectSyntheticSpan(newStore, fileSpan, filepath.Base(file), file); err != nil {
+				return err
+			}
+		}
+
+		ids, err := mergedSpanIDs(file, traces, fileSpan, seen, opts)
+		if err != nil {
+			return err
+		}
+
+		var fileStart, fileEnd time.Time
+		err = walkTraces(traces, func(tr *appdash.Trace) error {
+			if iv, err := traceInterval(tr); err == nil {
+				fileStart, fileEnd = widenTimespan(fileStart, fileEnd, iv.Start, iv.End)
+			}
+			id := ids[tr.Span.ID]
+			seen[mergeSpanKey{id.Trace, id.Span}] = file
+			return newStore.Collect(id, tr.Annotations...)
+		})
+		if err != nil {
+			return err
+		}
+
+		if opts.Reroot {
+			if err := collectTimespan(newStore, fileSpan, fileStart, fileEnd); err != nil {
+				return err
+			}
+			start, end = widenTimespan(start, end, fileStart, fileEnd)
+		}
+	}
+
+	if opts.Reroot {
+		if err := collectTimespan(newStore, root, start, end); err != nil {
+			return err
+		}
+	}
+
+	return writeMemoryStore(outputFile, newStore)
+}
+
+// Assigns the IDs the spans of a file get in the merged file. With
+// fileSpan set, the spans move to its trace and the roots become its
+// children.
+func mergedSpanIDs(
+	file string,
+	traces []*appdash.Trace,
+	fileSpan appdash.SpanID,
+	seen map[mergeSpanKey]string,
+	opts MergeOptions,
+) (map[appdash.SpanID]appdash.SpanID, error) {
+	rerooted := func(id appdash.SpanID, isRoot bool) appdash.SpanID {
+		if !opts.Reroot {
+			return id
+		}
+		id.Trace = fileSpan.Trace
+		if isRoot {
+			id.Parent = fileSpan.Span
+		}
+		return id
+	}
+
+	var collision error
+	assign := func(rename func(trace, id appdash.ID) appdash.ID) map[appdash.SpanID]appdash.SpanID {
+		ids := make(map[appdash.SpanID]appdash.SpanID)
+		mine := make(map[mergeSpanKey]bool)
+		var walk func(t *appdash.Trace, isRoot bool)
+		walk = func(t *appdash.Trace, isRoot bool) {
+			id := t.Span.ID
+			if rename != nil {
+				trace := id.Trace
+				id.Span = rename(trace, id.Span)
+				if id.Parent != 0 {
+					id.Parent = rename(trace, id.Parent)
+				}
+				if !opts.Reroot {
+					id.Trace = rename(trace, trace)
+				}
+			}
+			id = rerooted(id, isRoot)
+			key := mergeSpanKey{id.Trace, id.Span}
+			if collision == nil {
+				if other, ok := seen[key]; ok {
+					collision = fmt.Errorf("Span %s of %s collides with a span of %s", id, file, other)
+				} else if mine[key] {
+					collision = fmt.Errorf("Span %s of %s collides with another span of the file", id, file)
+				}
+			}
+			mine[key] = true
+			ids[t.Span.ID] = id
+			for _, sub := range t.Sub {
+				walk(sub, false)
+			}
+		}
+		for _, t := range traces {
+			walk(t, true)
+		}
+		return ids
+	}
+
+	ids := assign(nil)
+	if collision == nil {
+		return ids, nil
+	}
+	if !opts.RenameCollisions {
+		return nil, collision
+	}
+
+	// Spans of different traces may share IDs, so the new IDs are per
+	// trace.
+	renamed := make(map[mergeSpanKey]appdash.ID)
+	collision = nil
+	ids = assign(func(trace, id appdash.ID) appdash.ID {
+		key := mergeSpanKey{trace, id}
+		if _, ok := renamed[key]; !ok {
+			renamed[key] = appdash.NewRootSpanID().Span
+		}
+		return renamed[key]
+	})
+	return ids, collision
+}
+
+// Collects a span of the given name before its children, so that they
+// attach to it directly; its timespan is added once they are known.
+func collectSyntheticSpan(store *appdash.MemoryStore, id appdash.SpanID, name, file string) error {
+	anns, err := appdash.MarshalEvent(appdash.SpanName(name))
+	if err != nil {
+		return err
+	}
+	if file != "" {
+		anns = append(anns, appdash.Annotation{Key: mergeFileAnnotation, Value: []byte(file)})
+	}
+	return store.Collect(id, anns...)
+}
+
+func collectTimespan(store *appdash.MemoryStore, id appdash.SpanID, start, end time.Time) error {
+	if start.IsZero() {
+		return nil
+	}
+	anns, err := appdash.MarshalEvent(appdash.Timespan{S: start, E: end})
+	if err != nil {
+		return err
+	}
+	return store.Collect(id, anns...)
+}
+
+func widenTimespan(start, end, s, e time.Time) (time.Time, time.Time) {
+	if start.IsZero() || s.Before(start) {
+		start = s
+	}
+	if end.IsZero() || e.After(end) {
+		end = e
+	}
+	return start, end
+}
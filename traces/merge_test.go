@@ -0,0 +1,68 @@
+package traces
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func TestMerge(t *testing.T) {
+	first := writeTestTrace(t, "first.trace", testPulumiSpans())
+	second := writeTestTrace(t, "second.trace", testPulumiSpans())
+
+	otherStore := appdash.NewMemoryStore()
+	collectTestSpans(t, otherStore, 2, []testSpan{
+		{id: 2, name: "pulumi-language-nodejs", start: 500, end: 12000},
+	})
+	other := filepath.Join(t.TempDir(), "other.trace")
+	require.NoError(t, writeMemoryStore(other, otherStore))
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "merged.trace")
+
+	require.NoError(t, Merge([]string{first, other}, out, MergeOptions{}))
+	traces, err := readTracesFromFile(out)
+	require.NoError(t, err)
+	assert.Len(t, traces, 2)
+
+	err = Merge([]string{first, second}, out, MergeOptions{})
+	assert.ErrorContains(t, err, "collides with a span of "+first)
+
+	require.NoError(t, Merge([]string{first, second}, out, MergeOptions{RenameCollisions: true}))
+	traces, err = readTracesFromFile(out)
+	require.NoError(t, err)
+	require.Len(t, traces, 2)
+	for _, tr := range traces {
+		assert.Equal(t, "pulumi", tr.Span.Name())
+		assert.Len(t, tr.Sub, 2)
+	}
+
+	err = Merge([]string{first, second}, out, MergeOptions{Reroot: true})
+	assert.ErrorContains(t, err, "collides")
+
+	require.NoError(t, Merge([]string{first, second, other}, out, MergeOptions{Reroot: true, RenameCollisions: true}))
+	traces, err = readTracesFromFile(out)
+	require.NoError(t, err)
+	require.Len(t, traces, 1)
+
+	root := buildSpanTree(traces)[0]
+	assert.Equal(t, mergeRootName, root.name)
+	require.True(t, root.timed)
+	assert.Equal(t, testTraceStart, root.interval.Start)
+	assert.Equal(t, 12*time.Second, root.duration())
+
+	var files []string
+	for _, c := range root.children {
+		files = append(files, c.name)
+		assert.Equal(t, filepath.Base(spanAnnotation(c, mergeFileAnnotation)), c.name)
+		require.Len(t, c.children, 1)
+	}
+	// The children are ordered by start time, which ties for the copies.
+	assert.ElementsMatch(t, []string{"first.trace", "second.trace", "other.trace"}, files)
+	assert.Equal(t, "pulumi", root.children[0].children[0].name)
+	assert.Len(t, root.children[0].children[0].children, 2)
+}
@@ -1,226 +1,301 @@
 package traces
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/csv"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pulumi/pulumi-trace-tool/intervals"
 )
 
-type MetricsSink struct {
-	writeMetrics func(data []map[string]string) error
+// Receives the metrics rows computed by Metrics, one row per trace file
+// and benchmark phase.
+type MetricsSink interface {
+	WriteMetrics(data []map[string]string) error
+}
+
+// Adapts an ordinary function to a MetricsSink.
+type MetricsSinkFunc func(data []map[string]string) error
+
+func (f MetricsSinkFunc) WriteMetrics(data []map[string]string) error {
+	return f(data)
 }
 
 func NewCsvMetricsSink(writer io.Writer) MetricsSink {
-	return MetricsSink{
-		func(data []map[string]string) error {
-			return writeMetricsToCsvWriter(data, writer)
-		},
-	}
+	return NewOrderedCsvMetricsSink(writer, nil)
 }
 
+// Writes metrics as CSV with the columns in the given order, see
+// ColumnOrder.
+func NewOrderedCsvMetricsSink(writer io.Writer, order *ColumnOrder) MetricsSink {
+	return MetricsSinkFunc(func(data []map[string]string) error {
+		return writeMetricsToCsvWriter(data, writer, order)
+	})
+}
+
+// Writes metrics to a Parquet file with the columns of the default
+// metrics spec, see NewSpecParquetFileMetricsSink.
 func NewParquetFileMetricsSink(filePath string) MetricsSink {
-	return MetricsSink{
-		func(data []map[string]string) error {
-			records, err := parseParquetRecords(data)
-			if err != nil {
-				return err
-			}
-			return writeParquetRecords(filePath, records)
-		},
-	}
+	return NewSpecParquetFileMetricsSink(filePath, DefaultMetricsSpec())
 }
 
-func Metrics(csvFile string, filenameColumn string, sink MetricsSink) error {
-	aliases := metricAliases()
+// Writes metrics to a Parquet file. Columns defined by the spec have the
+// types it declares; the types of any other columns in the data are
+// inferred from their values.
+func NewSpecParquetFileMetricsSink(filePath string, spec *MetricsSpec) MetricsSink {
+	known := spec.columns()
+	return MetricsSinkFunc(func(data []map[string]string) error {
+		return writeParquetMetrics(filePath, inferParquetColumns(known, data), data)
+	})
+}
 
-	invAliases := make(map[string]string)
-	for k, vs := range aliases {
-		for _, v := range vs {
-			invAliases[v] = k
-		}
-	}
+// Writes metrics to a Parquet file with exactly the columns of the
+// schema.
+func NewSchemaParquetFileMetricsSink(filePath string, schema *ParquetSchema) MetricsSink {
+	return MetricsSinkFunc(func(data []map[string]string) error {
+		return writeParquetMetrics(filePath, schema.Columns, data)
+	})
+}
 
-	files := make(map[string]string)
+// Computes metrics with the default spec, see DefaultMetricsSpec.
+func Metrics(csvFile string, filenameColumn string, sink MetricsSink) error {
+	return MetricsWithSpec(csvFile, filenameColumn, DefaultMetricsSpec(), sink)
+}
 
-	// find set of source files in the data
-	err := readLargeCsvFile(csvFile, func(row map[string]string) error {
+// Computes a metrics row for every root span in the CSV data as
+// produced by ToCsv, aggregating the spans of the same trace file as
+// defined by the spec, and writes the rows to the sink.
+func MetricsWithSpec(csvFile string, filenameColumn string, spec *MetricsSpec, sink MetricsSink) error {
+	// Accumulators for every source file in the data, filled in a
+	// single pass over the CSV. The order slice remembers the order
+	// in which files were first seen so the output is stable.
+	files := make(map[string]*fileMetrics)
+	var order []string
+
+	accumulateMetricsFromRow := func(row map[string]string) error {
 		f := row[filenameColumn]
-		if f != "" {
-			files[f] = f
+		if f == "" {
+			return nil
 		}
-		return nil
-	})
-	if err != nil {
+
+		acc, seen := files[f]
+		if !seen {
+			acc = newFileMetrics(spec)
+			files[f] = acc
+			order = append(order, f)
+		}
+
+		return acc.accumulate(row)
+	}
+
+	accumulateTolerant := tolerateFaults(csvFile, accumulateMetricsFromRow)
+	if err := readLargeCsvFile(csvFile, accumulateTolerant); err != nil {
 		return err
 	}
 
 	var metrics []map[string]string
 
-	for f := range files {
-
-		var engDuration time.Duration
-		apiOverhead := &intervals.TimeTracker{}
-		var engStart time.Time
-		var haveEngStart bool
-		var pulumiApiEndpoint string
+	for _, f := range order {
+		acc := files[f]
 
-		miscMetrics := map[string]*intervals.TimeTracker{}
-		for _, metric := range metricsAccumulators() {
-			miscMetrics[metric] = &intervals.TimeTracker{}
+		emitMetricsFromRow := func(row map[string]string) error {
+			m, err := acc.emit(row, filenameColumn)
+			if err != nil {
+				return err
+			}
+			metrics = append(metrics, m)
+			return nil
 		}
 
-		precomputeMetricsFromRow := func(row map[string]string) error {
-			if row[filenameColumn] != f {
-				return nil
+		// Emit a metrics row for every all-encompassing root span,
+		// such as the top-level `pulumi` invocation.
+		emitTolerant := tolerateFaults(csvFile, emitMetricsFromRow)
+		for _, row := range acc.rootRows {
+			if err := emitTolerant(row); err != nil {
+				return err
 			}
+		}
+	}
 
-			for rowName, metric := range metricsAccumulators() {
-				if row["Name"] == rowName {
-					iv, err := spanInterval(row)
-					if err != nil {
-						return err
-					}
-					if err := miscMetrics[metric].Track(iv); err != nil {
-						return err
-					}
-				}
-			}
+	if err := sink.WriteMetrics(metrics); err != nil {
+		return err
+	}
 
-			if row["Name"] == "pulumi-plan" {
-				t0, err := spanStart(row)
-				if err != nil {
-					return err
-				}
-				engStart = t0
-				haveEngStart = true
-
-				dur, err := spanDuration(row)
-				if err != nil {
-					return err
-				}
-				engDuration = dur
-			}
+	return nil
+}
 
-			if row["api"] != "" {
-				pulumiApiEndpoint = row["api"]
-				iv, err := spanInterval(row)
-				if err != nil {
-					return err
-				}
-				if err := apiOverhead.Track(iv); err != nil {
-					return err
-				}
-			}
+// Per-file state accumulated while scanning the CSV data.
+type fileMetrics struct {
+	spec    *MetricsSpec
+	metrics []metricAccumulator
 
-			return nil
+	// Rows for the root spans; resolved into metrics once all the
+	// rows for the file have been seen.
+	rootRows []map[string]string
+}
+
+// State of a single MetricSpec; which fields are used depends on the
+// aggregate.
+type metricAccumulator struct {
+	union      *intervals.TimeTracker
+	sum        time.Duration
+	max        time.Duration
+	count      int
+	firstStart time.Time
+	haveStart  bool
+	last       intervals.Interval
+	haveLast   bool
+	value      string
+}
+
+func newFileMetrics(spec *MetricsSpec) *fileMetrics {
+	metrics := make([]metricAccumulator, len(spec.Metrics))
+	for i := range metrics {
+		metrics[i].union = &intervals.TimeTracker{}
+	}
+	return &fileMetrics{
+		spec:    spec,
+		metrics: metrics,
+	}
+}
+
+func (acc *fileMetrics) accumulate(row map[string]string) error {
+	if acc.spec.Root.matches(row["Name"]) {
+		acc.rootRows = append(acc.rootRows, row)
+	}
+
+	for i := range acc.spec.Metrics {
+		m := &acc.spec.Metrics[i]
+		if !m.matches(row) {
+			continue
 		}
 
-		precomputeTolerant := tolerateFaults(csvFile+"#precompute", precomputeMetricsFromRow)
-		if err := readLargeCsvFile(csvFile, precomputeTolerant); err != nil {
+		a := &acc.metrics[i]
+		switch m.Aggregate {
+		case countAggregate:
+			a.count++
+			continue
+		case valueAggregate:
+			a.value = row[m.Annotation]
+			continue
+		}
+
+		iv, err := spanInterval(row)
+		if err != nil {
 			return err
 		}
 
-		emitMetricsFromRow := func(row map[string]string) error {
-			if row[filenameColumn] != f {
-				return nil
+		switch m.Aggregate {
+		case unionAggregate:
+			if err := a.union.Track(iv); err != nil {
+				return err
 			}
-
-			// Detect the all-encompassing span collected from the
-			// top-level `pulumi` invocation.
-
-			if row["Name"] == "pulumi" {
-				m := make(map[string]string)
-
-				t0, err := spanStart(row)
-				if err != nil {
-					return err
-				}
-
-				m[benchmark_start] = row["Span.Start"]
-
-				// this is coming from `pulumi` CLI process, not a plugin
-				m[pulumi_process] = "pulumi"
-
-				// copy labels if found in aliases
-				for k, v := range row {
-					col, includeCol := invAliases[k]
-					if includeCol {
-						m[col] = v
-					}
-				}
-
-				// infer benchmark phase; example inputs:
-				//
-				// filename=aws-go-s3-folder-pulumi-update-initial.trace
-				// benchmark_name=aws-go-s3-folder
-				m[benchmark_phase] = ""
-
-				f := path.Base(row[filenameColumn])
-				if strings.HasPrefix(f, m[benchmark_name]+"-") {
-					s := strings.TrimPrefix(f, m[benchmark_name]+"-")
-					if strings.HasSuffix(s, ".trace") {
-						s = strings.TrimSuffix(s, ".trace")
-						m[benchmark_phase] = s
-					}
-				}
-
-				// use pre-computed things here
-				m[time_engine_ms] = ms(engDuration)
-				m[pulumi_api] = pulumiApiEndpoint
-				m[time_pulumi_api_ms] = ms(apiOverhead.TimeTaken())
-
-				for k, v := range miscMetrics {
-					m[k] = ms(v.TimeTaken())
-				}
-
-				if haveEngStart {
-					m[time_to_engine_ms] = ms(engStart.Sub(t0))
-				} else {
-					m[time_to_engine_ms] = ""
-				}
-
-				metrics = append(metrics, m)
+		case sumAggregate:
+			a.sum += iv.End.Sub(iv.Start)
+		case maxAggregate:
+			if d := iv.End.Sub(iv.Start); d > a.max {
+				a.max = d
 			}
-
-			return nil
+		case firstStartAggregate:
+			if !a.haveStart || iv.Start.Before(a.firstStart) {
+				a.firstStart = iv.Start
+				a.haveStart = true
+			}
+		case lastAggregate, lastStartAggregate:
+			a.last = iv
+			a.haveLast = true
 		}
+	}
 
-		emitTolerant := tolerateFaults(csvFile, emitMetricsFromRow)
-		if err := readLargeCsvFile(csvFile, emitTolerant); err != nil {
-			return err
+	return nil
+}
+
+func (acc *fileMetrics) emit(row map[string]string, filenameColumn string) (map[string]string, error) {
+	m := make(map[string]string)
+
+	t0, err := spanStart(row)
+	if err != nil {
+		return nil, err
+	}
+
+	m[benchmark_start] = row["Span.Start"]
+
+	m[pulumi_process] = rowProcessName(row)
+
+	// copy labels from the first annotation found
+	for _, l := range acc.spec.Labels {
+		for _, k := range l.Annotations {
+			v, found := row[k]
+			if !found {
+				continue
+			}
+			m[l.Column] = v
+			if v != "" {
+				break
+			}
 		}
 	}
 
-	if err := sink.writeMetrics(metrics); err != nil {
-		return err
+	// infer benchmark phase; example inputs:
+	//
+	// filename=aws-go-s3-folder-pulumi-update-initial.trace[.gz]
+	// benchmark_name=aws-go-s3-folder
+	m[benchmark_phase] = ""
+
+	f := path.Base(row[filenameColumn])
+	if name, ok := trimAppdashTraceExtension(f); ok && strings.HasPrefix(name, m[benchmark_name]+"-") {
+		m[benchmark_phase] = strings.TrimPrefix(name, m[benchmark_name]+"-")
 	}
 
-	return nil
+	for i, spec := range acc.spec.Metrics {
+		a := acc.metrics[i]
+		switch spec.Aggregate {
+		case unionAggregate:
+			m[spec.Column] = ms(a.union.TimeTaken())
+		case sumAggregate:
+			m[spec.Column] = ms(a.sum)
+		case maxAggregate:
+			m[spec.Column] = ms(a.max)
+		case countAggregate:
+			m[spec.Column] = strconv.Itoa(a.count)
+		case firstStartAggregate:
+			m[spec.Column] = ""
+			if a.haveStart {
+				m[spec.Column] = ms(a.firstStart.Sub(t0))
+			}
+		case lastAggregate:
+			m[spec.Column] = ms(a.last.End.Sub(a.last.Start))
+		case lastStartAggregate:
+			m[spec.Column] = ""
+			if a.haveLast {
+				m[spec.Column] = ms(a.last.Start.Sub(t0))
+			}
+		case valueAggregate:
+			m[spec.Column] = a.value
+		}
+	}
+
+	return m, nil
 }
 
-// Map span name to the duration sum counter.
-func metricsAccumulators() map[string]string {
-	return map[string]string{
-		"pulumi":                         time_total_ms,
-		"/pulumirpc.Engine/Log":          time_log_overhead_ms,
-		"api/patchCheckpoint":            time_patch_checkpoint_ms,
-		"/pulumirpc.LanguageRuntime/Run": time_language_runtime_run_ms,
-
-		"/pulumirpc.LanguageRuntime/GetRequiredPlugins": time_get_required_plugins_ms,
-		"/pulumirpc.ResourceMonitor/RegisterResource":   time_register_resource_ms,
-		"/pulumirpc.ResourceProvider/Configure":         time_resource_provider_configure_ms,
-		"/pulumirpc.ResourceProvider/Create":            time_resource_provider_create_ms,
+// Names the Pulumi process of a root span given as a row of
+// `traces.csv`, after the span as spanProcessName does: `pulumi` for the
+// CLI or the plugin name such as `pulumi-resource-aws`.
+func rowProcessName(row map[string]string) string {
+	if p := row[pulumi_process]; p != "" {
+		return p
 	}
+	if row["Name"] == "" {
+		return "unknown"
+	}
+	return row["Name"]
 }
 
 func spanStart(row map[string]string) (time.Time, error) {
@@ -267,13 +342,16 @@ func spanDuration(row map[string]string) (time.Duration, error) {
 }
 
 func readLargeCsvFile(csvFile string, handleRow func(map[string]string) error) error {
-	f, err := os.Open(csvFile)
+	reader, f, err := openInputFile(csvFile)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	reader := bufio.NewReader(f)
+	return readCsv(reader, handleRow)
+}
+
+func readCsv(reader io.Reader, handleRow func(map[string]string) error) error {
 	csvReader := csv.NewReader(reader)
 
 	header, err := csvReader.Read()
@@ -367,41 +445,23 @@ func tolerateFaults(
 	}
 }
 
-func writeMetricsToCsvWriter(data []map[string]string, writer io.Writer) error {
-	csvWriter := csv.NewWriter(writer)
-	defer csvWriter.Flush()
-
-	columns := make(map[string]int)
-
-	addColumn := func(name string) {
-		_, seen := columns[name]
-		if !seen {
-			n := len(columns)
-			columns[name] = n
-		}
-	}
-
-	for _, row := range data {
-		for k := range row {
-			addColumn(k)
-		}
+func writeMetricsToCsvWriter(data []map[string]string, writer io.Writer, order *ColumnOrder) error {
+	columnNames, err := order.apply(metricsColumns(data))
+	if err != nil {
+		return err
 	}
 
-	columnNames := make([]string, len(columns))
-
-	for k, j := range columns {
-		columnNames[j] = k
-	}
+	csvWriter := csv.NewWriter(writer)
 
 	if err := csvWriter.Write(columnNames); err != nil {
 		return err
 	}
 
 	for _, row := range data {
-		values := make([]string, len(columns))
+		values := make([]string, len(columnNames))
 
-		for k, v := range row {
-			values[columns[k]] = v
+		for j, k := range columnNames {
+			values[j] = row[k]
 		}
 
 		if err := csvWriter.Write(values); err != nil {
@@ -409,7 +469,23 @@ func writeMetricsToCsvWriter(data []map[string]string, writer io.Writer) error {
 		}
 	}
 
-	return nil
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// Column names of metrics rows, ordered by orderColumns.
+func metricsColumns(data []map[string]string) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	for _, row := range data {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+	return orderColumns(columns)
 }
 
 func parseTime(str string) (time.Time, error) {
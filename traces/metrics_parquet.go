@@ -4,162 +4,195 @@
 package traces
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/xitongsys/parquet-go-source/local"
 	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
 	"github.com/xitongsys/parquet-go/writer"
 )
 
-// TestCommandStats is a collection of data related to running a single command during a test.
-type ParquetRecord struct {
-	Benchmark_language    *string `parquet:"name=benchmark_language, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Benchmark_name        *string `parquet:"name=benchmark_name, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Benchmark_phase       *string `parquet:"name=benchmark_phase, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Benchmark_provider    *string `parquet:"name=benchmark_provider, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Benchmark_repo        *string `parquet:"name=benchmark_repo, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Benchmark_runtime     *string `parquet:"name=benchmark_runtime, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Benchmark_start       *string `parquet:"name=benchmark_start, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Mem_frees             *int64  `parquet:"name=mem_frees, type=INT64"`
-	Mem_heap_alloc_max    *int64  `parquet:"name=mem_heap_alloc_max, type=INT64"`
-	Mem_heap_idle_max     *int64  `parquet:"name=mem_heap_idle_max, type=INT64"`
-	Mem_heap_inuse_max    *int64  `parquet:"name=mem_heap_inuse_max, type=INT64"`
-	Mem_heap_objects_max  *int64  `parquet:"name=mem_heap_objects_max, type=INT64"`
-	Mem_heap_released_max *int64  `parquet:"name=mem_heap_released_max, type=INT64"`
-	Mem_heap_sys_max      *int64  `parquet:"name=mem_heap_sys_max, type=INT64"`
-	Mem_mallocs           *int64  `parquet:"name=mem_mallocs, type=INT64"`
-	Mem_num_gc            *int64  `parquet:"name=mem_num_gc, type=INT64"`
-	Mem_pause_total_ns    *int64  `parquet:"name=mem_pause_total_ns, type=INT64"`
-	Mem_stack_in_use_max  *int64  `parquet:"name=mem_stack_in_use_max, type=INT64"`
-	Mem_stack_sys_max     *int64  `parquet:"name=mem_stack_sys_max, type=INT64"`
-	Mem_sys_max           *int64  `parquet:"name=mem_sys_max, type=INT64"`
-	Mem_total_alloc       *int64  `parquet:"name=mem_total_alloc, type=INT64"`
-	Pulumi_api            *string `parquet:"name=pulumi_api, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Pulumi_commandline    *string `parquet:"name=pulumi_commandline, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Pulumi_process        *string `parquet:"name=pulumi_process, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Pulumi_version        *string `parquet:"name=pulumi_version, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Runner_arch           *string `parquet:"name=runner_arch, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Runner_num_cpu        *int64  `parquet:"name=runner_num_cpu, type=INT64"`
-	Runner_os             *string `parquet:"name=runner_os, type=BYTE_ARRAY, convertedtype=UTF8"`
-	Time_engine_ms        *int64  `parquet:"name=time_engine_ms, type=INT64"`
-	Time_log_overhead_ms  *int64  `parquet:"name=time_log_overhead_ms, type=INT64"`
-	Time_pulumi_api_ms    *int64  `parquet:"name=time_pulumi_api_ms, type=INT64"`
-	Time_to_engine_ms     *int64  `parquet:"name=time_to_engine_ms, type=INT64"`
-	Time_total_ms         *int64  `parquet:"name=time_total_ms, type=INT64"`
-}
+// Writes metrics rows to a Parquet file with one nullable column per
+// schema column. Empty values are written as null, except in string
+// columns; other columns present in the rows but not in the schema are
+// left out.
+func writeParquetMetrics(filePath string, columns []ParquetColumn, data []map[string]string) error {
+	if strings.HasSuffix(".parquet.snappy", filePath) {
+		return fmt.Errorf("Parquet file path should have the .parquet.snappy extension: %s", filePath)
+	}
 
-func parseParquetRecord(dataRow map[string]string) (ParquetRecord, error) {
-	r := ParquetRecord{}
-	var err error
+	schema, err := parquetSchema(columns)
+	if err != nil {
+		return err
+	}
 
-	newStrPtr := func(value string) *string {
-		return &value
+	fw, err := local.NewLocalFileWriter(filePath)
+	if err != nil {
+		return err
 	}
+	defer fw.Close()
 
-	newI64Ptr := func(value int64) *int64 {
-		return &value
+	pw, err := writer.NewJSONWriter(schema, fw, 2)
+	if err != nil {
+		return err
 	}
 
-	str := func(out **string, key string) {
-		strVal, hasVal := dataRow[key]
-		if hasVal {
-			*out = newStrPtr(strVal)
+	pw.RowGroupSize = 128 * 1024 * 1024 // 128M
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	for _, row := range data {
+		record, err := parquetRecord(columns, row)
+		if err != nil {
+			return err
+		}
+		if err := pw.Write(record); err != nil {
+			return err
 		}
 	}
 
-	i64 := func(out **int64, key string) {
-		strVal, hasVal := dataRow[key]
-		if hasVal && strVal != "" {
-			if n, e := strconv.ParseInt(strVal, 10, 64); e == nil {
-				*out = newI64Ptr(n)
-			} else {
-				err = fmt.Errorf("Failed to parse integer column %s value %s as an int64: %w",
-					key, strVal, e)
-			}
-		}
+	if err = pw.WriteStop(); err != nil {
+		return err
 	}
 
-	str(&r.Benchmark_language, "benchmark_language")
-	str(&r.Benchmark_name, "benchmark_name")
-	str(&r.Benchmark_phase, "benchmark_phase")
-	str(&r.Benchmark_provider, "benchmark_provider")
-	str(&r.Benchmark_repo, "benchmark_repo")
-	str(&r.Benchmark_runtime, "benchmark_runtime")
-	str(&r.Benchmark_start, "benchmark_start")
-	str(&r.Pulumi_api, "pulumi_api")
-	str(&r.Pulumi_commandline, "pulumi_commandline")
-	str(&r.Pulumi_process, "pulumi_process")
-	str(&r.Pulumi_version, "pulumi_version")
-	str(&r.Runner_arch, "runner_arch")
-	str(&r.Runner_os, "runner_os")
-
-	i64(&r.Mem_frees, "mem_frees")
-	i64(&r.Mem_heap_alloc_max, "mem_heap_alloc_max")
-	i64(&r.Mem_heap_idle_max, "mem_heap_idle_max")
-	i64(&r.Mem_heap_inuse_max, "mem_heap_inuse_max")
-	i64(&r.Mem_heap_objects_max, "mem_heap_objects_max")
-	i64(&r.Mem_heap_released_max, "mem_heap_released_max")
-	i64(&r.Mem_heap_sys_max, "mem_heap_sys_max")
-	i64(&r.Mem_mallocs, "mem_mallocs")
-	i64(&r.Mem_num_gc, "mem_num_gc")
-	i64(&r.Mem_pause_total_ns, "mem_pause_total_ns")
-	i64(&r.Mem_stack_in_use_max, "mem_stack_in_use_max")
-	i64(&r.Mem_stack_sys_max, "mem_stack_sys_max")
-	i64(&r.Mem_sys_max, "mem_sys_max")
-	i64(&r.Mem_total_alloc, "mem_total_alloc")
-	i64(&r.Runner_num_cpu, "runner_num_cpu")
-	i64(&r.Time_engine_ms, "time_engine_ms")
-	i64(&r.Time_log_overhead_ms, "time_log_overhead_ms")
-	i64(&r.Time_pulumi_api_ms, "time_pulumi_api_ms")
-	i64(&r.Time_to_engine_ms, "time_to_engine_ms")
-	i64(&r.Time_total_ms, "time_total_ms")
-
-	return r, err
+	return nil
 }
 
-func parseParquetRecords(dataRows []map[string]string) ([]ParquetRecord, error) {
-	var out []ParquetRecord
-	for _, row := range dataRows {
-		r, err := parseParquetRecord(row)
-		if err != nil {
-			return nil, err
+// Builds the JSON schema definition understood by parquet-go.
+func parquetSchema(columns []ParquetColumn) (string, error) {
+	type field struct {
+		Tag string
+	}
+	type schema struct {
+		Tag    string
+		Fields []field
+	}
+
+	s := schema{Tag: "name=parquet_go_root, repetitiontype=REQUIRED"}
+	for _, c := range columns {
+		if strings.ContainsAny(c.Name, ",=") {
+			return "", fmt.Errorf("Unsupported Parquet column name %q", c.Name)
+		}
+		var typ string
+		switch c.Type {
+		case int64ColumnType:
+			typ = "type=INT64"
+		case float64ColumnType:
+			typ = "type=DOUBLE"
+		case timestampColumnType:
+			typ = "type=INT64, convertedtype=TIMESTAMP_MICROS"
+		default:
+			typ = "type=BYTE_ARRAY, convertedtype=UTF8"
 		}
-		out = append(out, r)
+		s.Fields = append(s.Fields, field{
+			Tag: fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", c.Name, typ),
+		})
 	}
-	return out, nil
+
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
 }
 
-func writeParquetRecords(filePath string, records []ParquetRecord) error {
-	if strings.HasSuffix(".parquet.snappy", filePath) {
-		return fmt.Errorf("Parquet file path should have the .parquet.snappy extension: %s", filePath)
+// Encodes a row as the JSON object expected by the parquet-go JSON
+// writer.
+func parquetRecord(columns []ParquetColumn, row map[string]string) (string, error) {
+	record := make(map[string]interface{})
+	for _, c := range columns {
+		v, hasVal := row[c.Name]
+		if !hasVal {
+			continue
+		}
+		if v == "" && c.Type != stringColumnType {
+			continue
+		}
+		switch c.Type {
+		case int64ColumnType:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("Failed to parse integer column %s value %s as an int64: %w",
+					c.Name, v, err)
+			}
+			record[c.Name] = n
+		case float64ColumnType:
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return "", fmt.Errorf("Failed to parse float column %s value %s as a float64: %w",
+					c.Name, v, err)
+			}
+			record[c.Name] = x
+		case timestampColumnType:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return "", fmt.Errorf("Failed to parse timestamp column %s value %s: %w",
+					c.Name, v, err)
+			}
+			record[c.Name] = t.UnixMicro()
+		default:
+			record[c.Name] = v
+		}
 	}
 
-	fw, err := local.NewLocalFileWriter(filePath)
+	buf, err := json.Marshal(record)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer fw.Close()
+	return string(buf), nil
+}
 
-	pw, err := writer.NewParquetWriter(fw, new(ParquetRecord), 2)
+// Reads any flat Parquet file, such as one written by
+// NewParquetFileMetricsSink, into rows keyed by column name. Null
+// values are left out of the rows and timestamps are formatted as
+// RFC3339.
+func readParquetMetrics(filePath string) ([]map[string]string, error) {
+	fr, err := local.NewLocalFileReader(filePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer fr.Close()
 
-	pw.RowGroupSize = 128 * 1024 * 1024 // 128M
-	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	pr, err := reader.NewParquetReader(fr, nil, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer pr.ReadStop()
 
-	for _, r := range records {
-		err := pw.Write(r)
-		if err != nil {
-			return err
-		}
+	objs, err := pr.ReadByNumber(int(pr.GetNumRows()))
+	if err != nil {
+		return nil, err
 	}
 
-	if err = pw.WriteStop(); err != nil {
-		return err
+	// Fields of the generated row type follow the schema elements
+	// under the root.
+	infos := pr.SchemaHandler.Infos[1:]
+	elements := pr.SchemaHandler.SchemaElements[1:]
+
+	var rows []map[string]string
+	for _, obj := range objs {
+		v := reflect.ValueOf(obj)
+		row := make(map[string]string)
+		for i := 0; i < v.NumField() && i < len(infos); i++ {
+			f := v.Field(i)
+			if f.Kind() == reflect.Ptr {
+				if f.IsNil() {
+					continue
+				}
+				f = f.Elem()
+			}
+			value := fmt.Sprintf("%v", f.Interface())
+			if ct := elements[i].ConvertedType; ct != nil && *ct == parquet.ConvertedType_TIMESTAMP_MICROS {
+				value = time.UnixMicro(f.Int()).UTC().Format(time.RFC3339Nano)
+			}
+			row[infos[i].ExName] = value
+		}
+		rows = append(rows, row)
 	}
 
-	return nil
+	return rows, nil
 }
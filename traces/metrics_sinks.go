@@ -0,0 +1,35 @@
+package traces
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Writes every metrics row as a JSON object on its own line.
+func NewJsonLinesMetricsSink(writer io.Writer) MetricsSink {
+	return MetricsSinkFunc(func(data []map[string]string) error {
+		encoder := json.NewEncoder(writer)
+		for _, row := range data {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Writes metrics rows as an aligned text table for reading in a
+// terminal.
+func NewTableMetricsSink(writer io.Writer) MetricsSink {
+	return MetricsSinkFunc(func(data []map[string]string) error {
+		records := make([]map[string]interface{}, 0, len(data))
+		for _, row := range data {
+			rec := make(map[string]interface{}, len(row))
+			for k, v := range row {
+				rec[k] = v
+			}
+			records = append(records, rec)
+		}
+		return writeRecords(writer, tableOutputFormat, metricsColumns(data), records)
+	})
+}
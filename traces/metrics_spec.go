@@ -0,0 +1,273 @@
+// Declarative definitions of the metrics computed by Metrics, loaded
+// from YAML or JSON.
+
+package traces
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Aggregations of the spans matched by a metric.
+const (
+	unionAggregate      = "union"
+	sumAggregate        = "sum"
+	countAggregate      = "count"
+	maxAggregate        = "max"
+	firstStartAggregate = "first_start"
+	lastAggregate       = "last"
+	lastStartAggregate  = "last_start"
+	valueAggregate      = "value"
+)
+
+//go:embed default_metrics.yaml
+var defaultMetricsSpec []byte
+
+// Defines the metrics rows: which spans start a row, the labels copied
+// from them and the metrics aggregated over the spans of the same file.
+// See default_metrics.yaml for an example.
+type MetricsSpec struct {
+	Root    SpanMatcher  `yaml:"root" json:"root"`
+	Labels  []LabelSpec  `yaml:"labels" json:"labels"`
+	Metrics []MetricSpec `yaml:"metrics" json:"metrics"`
+}
+
+// Matches span names exactly, by prefix or by regular expression. At
+// most one of the fields may be set; an empty matcher matches every
+// span.
+type SpanMatcher struct {
+	Name   string `yaml:"name,omitempty" json:"name,omitempty"`
+	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	Regex  string `yaml:"regex,omitempty" json:"regex,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// A column copied from the first of the annotations present on the root
+// span.
+type LabelSpec struct {
+	Column      string   `yaml:"column" json:"column"`
+	Annotations []string `yaml:"annotations" json:"annotations"`
+
+	// Column type, see ParquetColumn; string by default.
+	Type string `yaml:"type,omitempty" json:"type,omitempty"`
+}
+
+// A column aggregated over the matching spans of a file.
+type MetricSpec struct {
+	Column string      `yaml:"column" json:"column"`
+	Span   SpanMatcher `yaml:"span,omitempty" json:"span,omitempty"`
+
+	// Regular expressions the annotations of a span must match; an
+	// empty expression only requires the annotation to be non-empty.
+	Annotations map[string]string `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+
+	// One of union, sum, count, max, first_start, last, last_start or
+	// value.
+	Aggregate string `yaml:"aggregate" json:"aggregate"`
+
+	// Annotation reported by the value aggregate.
+	Annotation string `yaml:"annotation,omitempty" json:"annotation,omitempty"`
+
+	annotations map[string]*regexp.Regexp
+}
+
+// The metrics computed when no spec is given.
+func DefaultMetricsSpec() *MetricsSpec {
+	spec, err := parseMetricsSpec(defaultMetricsSpec, false)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid default metrics spec: %v", err))
+	}
+	return spec
+}
+
+// Loads a metrics spec from a YAML file, or from a JSON file if the
+// path ends in .json.
+func LoadMetricsSpec(path string) (*MetricsSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := parseMetricsSpec(data, strings.HasSuffix(path, ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load metrics spec %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+func parseMetricsSpec(data []byte, isJSON bool) (*MetricsSpec, error) {
+	var spec MetricsSpec
+	if err := decodeSpec(data, isJSON, &spec); err != nil {
+		return nil, err
+	}
+	if err := spec.compile(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// Decodes YAML or JSON, rejecting unknown fields.
+func decodeSpec(data []byte, isJSON bool, spec interface{}) error {
+	if isJSON {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(spec)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	return decoder.Decode(spec)
+}
+
+// Validates the spec and compiles its regular expressions.
+func (spec *MetricsSpec) compile() error {
+	if err := spec.Root.compile(); err != nil {
+		return fmt.Errorf("root: %w", err)
+	}
+
+	columns := map[string]bool{
+		benchmark_start: true,
+		benchmark_phase: true,
+		pulumi_process:  true,
+	}
+	addColumn := func(column string) error {
+		if column == "" {
+			return fmt.Errorf("missing column name")
+		}
+		if columns[column] {
+			return fmt.Errorf("duplicate column %q", column)
+		}
+		columns[column] = true
+		return nil
+	}
+
+	for i := range spec.Labels {
+		l := &spec.Labels[i]
+		if err := addColumn(l.Column); err != nil {
+			return fmt.Errorf("label %d: %w", i+1, err)
+		}
+		if len(l.Annotations) == 0 {
+			return fmt.Errorf("label %q: no annotations", l.Column)
+		}
+		if l.Type != "" {
+			if err := checkColumnType(l.Type); err != nil {
+				return fmt.Errorf("label %q: %w", l.Column, err)
+			}
+		}
+	}
+
+	for i := range spec.Metrics {
+		m := &spec.Metrics[i]
+		if err := addColumn(m.Column); err != nil {
+			return fmt.Errorf("metric %d: %w", i+1, err)
+		}
+		if err := m.Span.compile(); err != nil {
+			return fmt.Errorf("metric %q: %w", m.Column, err)
+		}
+		switch m.Aggregate {
+		case unionAggregate, sumAggregate, countAggregate, maxAggregate, firstStartAggregate,
+			lastAggregate, lastStartAggregate:
+		case valueAggregate:
+			if m.Annotation == "" {
+				return fmt.Errorf("metric %q: the value aggregate needs an annotation", m.Column)
+			}
+		default:
+			return fmt.Errorf("metric %q: unknown aggregate %q", m.Column, m.Aggregate)
+		}
+		m.annotations = make(map[string]*regexp.Regexp)
+		for k, expr := range m.Annotations {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("metric %q: annotation %s: %w", m.Column, k, err)
+			}
+			m.annotations[k] = re
+		}
+	}
+
+	return nil
+}
+
+func (sm *SpanMatcher) compile() error {
+	set := 0
+	for _, s := range []string{sm.Name, sm.Prefix, sm.Regex} {
+		if s != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of name, prefix and regex may be set")
+	}
+	if sm.Regex != "" {
+		re, err := regexp.Compile(sm.Regex)
+		if err != nil {
+			return err
+		}
+		sm.regex = re
+	}
+	return nil
+}
+
+func (sm *SpanMatcher) matches(name string) bool {
+	switch {
+	case sm.Name != "":
+		return name == sm.Name
+	case sm.Prefix != "":
+		return strings.HasPrefix(name, sm.Prefix)
+	case sm.regex != nil:
+		return sm.regex.MatchString(name)
+	default:
+		return true
+	}
+}
+
+// Whether a span, given as a row of `traces.csv`, counts towards the
+// metric.
+func (m *MetricSpec) matches(row map[string]string) bool {
+	if !m.Span.matches(row["Name"]) {
+		return false
+	}
+	for k, re := range m.annotations {
+		v := row[k]
+		if v == "" || !re.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Type of the metric column: value aggregates are strings and all
+// others are integers.
+func (m *MetricSpec) columnType() string {
+	if m.Aggregate == valueAggregate {
+		return stringColumnType
+	}
+	return int64ColumnType
+}
+
+// Columns of the metrics rows with their types, in spec order after the
+// columns every row has.
+func (spec *MetricsSpec) columns() []ParquetColumn {
+	columns := []ParquetColumn{
+		{benchmark_start, stringColumnType},
+		{benchmark_phase, stringColumnType},
+		{pulumi_process, stringColumnType},
+	}
+	for _, l := range spec.Labels {
+		typ := l.Type
+		if typ == "" {
+			typ = stringColumnType
+		}
+		columns = append(columns, ParquetColumn{l.Column, typ})
+	}
+	for i := range spec.Metrics {
+		m := &spec.Metrics[i]
+		columns = append(columns, ParquetColumn{m.Column, m.columnType()})
+	}
+	return columns
+}
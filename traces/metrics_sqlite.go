@@ -0,0 +1,137 @@
+package traces
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	// Registers the pure Go "sqlite" database/sql driver.
+	_ "modernc.org/sqlite"
+)
+
+// Column recording when a row was appended to a SQLite metrics table.
+const sqliteRecordedAtColumn = "recorded_at"
+
+// Appends metrics rows to a table of a SQLite database, creating the
+// database and table as needed. Columns missing from an existing table
+// are added, so that rows of every run accumulate in one place and can
+// be queried over time.
+func NewSqliteMetricsSink(dbPath, table string) MetricsSink {
+	return MetricsSinkFunc(func(data []map[string]string) error {
+		db, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			return fmt.Errorf("Failed to open SQLite database %s: %w", dbPath, err)
+		}
+		defer db.Close()
+
+		if err := writeSqliteMetrics(db, table, data); err != nil {
+			return fmt.Errorf("Failed to write metrics to SQLite database %s: %w", dbPath, err)
+		}
+		return nil
+	})
+}
+
+func writeSqliteMetrics(db *sql.DB, table string, data []map[string]string) error {
+	columns := append([]string{sqliteRecordedAtColumn}, metricsColumns(data)...)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			noErr(tx.Rollback())
+		}
+	}()
+
+	if err = ensureSqliteColumns(tx, table, columns, data); err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = sqliteIdent(c)
+	}
+	//nolint:gosec // identifiers are quoted, values are bound
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		sqliteIdent(table),
+		strings.Join(quoted, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+
+	recordedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, row := range data {
+		values := make([]interface{}, len(columns))
+		values[0] = recordedAt
+		for i, c := range columns[1:] {
+			if v := row[c]; v != "" {
+				values[i+1] = v
+			}
+		}
+		if _, err = tx.Exec(insert, values...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Creates the table if it does not exist yet and adds any missing
+// columns, typed by the values seen in data.
+func ensureSqliteColumns(tx *sql.Tx, table string, columns []string, data []map[string]string) error {
+	existing := make(map[string]bool)
+
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			noErr(rows.Close())
+			return err
+		}
+		existing[name] = true
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	var defs []string
+	for _, c := range columns {
+		if !existing[c] {
+			defs = append(defs, sqliteIdent(c)+" "+sqliteColumnType(c, data))
+		}
+	}
+
+	if len(existing) == 0 {
+		_, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", sqliteIdent(table), strings.Join(defs, ", ")))
+		return err
+	}
+
+	for _, def := range defs {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", sqliteIdent(table), def)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// INTEGER or REAL for numeric columns, TEXT otherwise.
+func sqliteColumnType(column string, data []map[string]string) string {
+	if column == sqliteRecordedAtColumn {
+		return "TEXT"
+	}
+	switch inferColumnType(column, data) {
+	case int64ColumnType:
+		return "INTEGER"
+	case float64ColumnType:
+		return "REAL"
+	default:
+		return "TEXT"
+	}
+}
+
+func sqliteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
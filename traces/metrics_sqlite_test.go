@@ -0,0 +1,45 @@
+package traces
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSqliteMetricsSinkAppends(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "metrics.db")
+	sink := NewSqliteMetricsSink(dbPath, "metrics")
+
+	require.NoError(t, sink.WriteMetrics([]map[string]string{
+		{benchmark_name: "a", time_total_ms: "100"},
+	}))
+	require.NoError(t, sink.WriteMetrics([]map[string]string{
+		{benchmark_name: "a", time_total_ms: "120", time_engine_ms: "90"},
+	}))
+
+	db, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT time_total_ms, time_engine_ms FROM metrics ORDER BY time_total_ms`)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var totals []int64
+	var engine []sql.NullInt64
+	for rows.Next() {
+		var total int64
+		var eng sql.NullInt64
+		require.NoError(t, rows.Scan(&total, &eng))
+		totals = append(totals, total)
+		engine = append(engine, eng)
+	}
+	require.NoError(t, rows.Err())
+
+	assert.Equal(t, []int64{100, 120}, totals)
+	assert.False(t, engine[0].Valid)
+	assert.Equal(t, int64(90), engine[1].Int64)
+}
@@ -0,0 +1,309 @@
+package traces
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestCsv(t *testing.T, rows [][]string) string {
+	p := filepath.Join(t.TempDir(), "traces.csv")
+	f, err := os.Create(p)
+	require.NoError(t, err)
+	defer f.Close()
+	w := csv.NewWriter(f)
+	require.NoError(t, w.WriteAll(rows))
+	return p
+}
+
+func TestMetricsPerFile(t *testing.T) {
+	csvFile := writeTestCsv(t, [][]string{
+		{"Name", "Span.Start", "Span.End", "benchmark_name", "api", "filename"},
+		{"/pulumirpc.ResourceMonitor/RegisterResource",
+			"2023-01-01T00:00:02Z", "2023-01-01T00:00:04Z", "", "", "b-pulumi-preview.trace"},
+		{"pulumi",
+			"2023-01-01T00:00:00Z", "2023-01-01T00:00:10Z", "a", "", "a-pulumi-update-initial.trace"},
+		{"pulumi-plan",
+			"2023-01-01T00:00:01Z", "2023-01-01T00:00:09Z", "", "", "a-pulumi-update-initial.trace"},
+		{"api/patchCheckpoint",
+			"2023-01-01T00:00:03Z", "2023-01-01T00:00:05Z", "", "https://api.pulumi.com", "a-pulumi-update-initial.trace"},
+		{"api/patchCheckpoint",
+			"2023-01-01T00:00:04Z", "2023-01-01T00:00:06Z", "", "https://api.pulumi.com", "a-pulumi-update-initial.trace"},
+		{"pulumi",
+			"2023-01-01T00:00:00Z", "2023-01-01T00:00:05Z", "b", "", "b-pulumi-preview.trace"},
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, Metrics(csvFile, "filename", NewCsvMetricsSink(&buf)))
+
+	var rows []map[string]string
+	require.NoError(t, readCsv(&buf, func(row map[string]string) error {
+		rows = append(rows, row)
+		return nil
+	}))
+	require.Len(t, rows, 2)
+
+	// Files are emitted in the order they first appear in the data.
+	b, a := rows[0], rows[1]
+
+	assert.Equal(t, "b", b[benchmark_name])
+	assert.Equal(t, "pulumi-preview", b[benchmark_phase])
+	assert.Equal(t, "5000", b[time_total_ms])
+	assert.Equal(t, "2000", b[time_register_resource_ms])
+	assert.Equal(t, "", b[time_to_engine_ms])
+
+	assert.Equal(t, "a", a[benchmark_name])
+	assert.Equal(t, "pulumi-update-initial", a[benchmark_phase])
+	assert.Equal(t, "10000", a[time_total_ms])
+	assert.Equal(t, "8000", a[time_engine_ms])
+	assert.Equal(t, "1000", a[time_to_engine_ms])
+	assert.Equal(t, "3000", a[time_pulumi_api_ms])
+	assert.Equal(t, "3000", a[time_patch_checkpoint_ms])
+	assert.Equal(t, "0", a[time_register_resource_ms])
+	assert.Equal(t, "https://api.pulumi.com", a[pulumi_api])
+	assert.Equal(t, "pulumi", a[pulumi_process])
+}
+
+func TestJsonLinesMetricsSink(t *testing.T) {
+	data := []map[string]string{
+		{benchmark_name: "a", time_total_ms: "10000"},
+		{benchmark_name: "b", "extra": "x"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, NewJsonLinesMetricsSink(&buf).WriteMetrics(data))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	for i, line := range lines {
+		var row map[string]string
+		require.NoError(t, json.Unmarshal([]byte(line), &row))
+		assert.Equal(t, data[i], row)
+	}
+
+	buf.Reset()
+	require.NoError(t, NewJsonLinesMetricsSink(&buf).WriteMetrics(nil))
+	assert.Empty(t, buf.String())
+}
+
+func TestTableMetricsSink(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, NewTableMetricsSink(&buf).WriteMetrics([]map[string]string{
+		{benchmark_name: "a", time_total_ms: "10000"},
+		{benchmark_name: "bbbbbbbbbbbbbbbb", "extra": "x"},
+	}))
+
+	// Well-known columns come first, then the others by name; missing
+	// values are blank.
+	assert.Equal(t, ""+
+		"benchmark_name    extra  time_total_ms\n"+
+		"a                        10000\n"+
+		"bbbbbbbbbbbbbbbb  x      \n", buf.String())
+}
+
+func TestMetricsLastPlanSpan(t *testing.T) {
+	// A preview followed by an update: the update counts.
+	csvFile := writeTestCsv(t, [][]string{
+		{"Name", "Span.Start", "Span.End", "filename"},
+		{"pulumi", "2023-01-01T00:00:00Z", "2023-01-01T00:00:10Z", "a.trace"},
+		{"pulumi-plan", "2023-01-01T00:00:01Z", "2023-01-01T00:00:03Z", "a.trace"},
+		{"pulumi-plan", "2023-01-01T00:00:04Z", "2023-01-01T00:00:05Z", "a.trace"},
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, Metrics(csvFile, "filename", NewCsvMetricsSink(&buf)))
+	var rows []map[string]string
+	require.NoError(t, readCsv(&buf, func(row map[string]string) error {
+		rows = append(rows, row)
+		return nil
+	}))
+	require.Len(t, rows, 1)
+	assert.Equal(t, "1000", rows[0][time_engine_ms])
+	assert.Equal(t, "4000", rows[0][time_to_engine_ms])
+}
+
+func TestMetricsPluginRoot(t *testing.T) {
+	spec, err := parseMetricsSpec([]byte(`
+root: {prefix: pulumi-resource-}
+metrics:
+  - column: create_ms
+    span: {name: /pulumirpc.ResourceProvider/Create}
+    aggregate: sum
+`), false)
+	require.NoError(t, err)
+
+	csvFile := writeTestCsv(t, [][]string{
+		{"Name", "Span.Start", "Span.End", "filename"},
+		{"pulumi-resource-aws", "2023-01-01T00:00:00Z", "2023-01-01T00:00:10Z", "a.trace"},
+		{"/pulumirpc.ResourceProvider/Create", "2023-01-01T00:00:01Z", "2023-01-01T00:00:03Z", "a.trace"},
+	})
+
+	var rows []map[string]string
+	require.NoError(t, MetricsWithSpec(csvFile, "filename", spec, MetricsSinkFunc(func(data []map[string]string) error {
+		rows = data
+		return nil
+	})))
+	require.Len(t, rows, 1)
+	assert.Equal(t, "pulumi-resource-aws", rows[0][pulumi_process])
+	assert.Equal(t, "2000", rows[0]["create_ms"])
+}
+
+func TestMetricsWithSpec(t *testing.T) {
+	specFile := filepath.Join(t.TempDir(), "metrics.yaml")
+	require.NoError(t, os.WriteFile(specFile, []byte(`
+root: {name: pulumi}
+labels:
+  - column: provider
+    annotations: [benchmark_provider, benchmark_cloud]
+metrics:
+  - column: rpc_count
+    span: {prefix: /pulumirpc.}
+    aggregate: count
+  - column: rpc_sum_ms
+    span: {regex: '^/pulumirpc\.ResourceProvider/(Create|Update)$'}
+    aggregate: sum
+  - column: rpc_max_ms
+    span: {prefix: /pulumirpc.}
+    aggregate: max
+  - column: aws_ms
+    annotations: {pulumi-decorator: '^aws:'}
+    aggregate: union
+  - column: first_rpc_ms
+    span: {prefix: /pulumirpc.}
+    aggregate: first_start
+`), 0o600))
+
+	spec, err := LoadMetricsSpec(specFile)
+	require.NoError(t, err)
+
+	csvFile := writeTestCsv(t, [][]string{
+		{"Name", "Span.Start", "Span.End", "benchmark_provider", "benchmark_cloud", "pulumi-decorator", "filename"},
+		{"pulumi", "2023-01-01T00:00:00Z", "2023-01-01T00:00:10Z", "", "aws", "", "a.trace"},
+		{"/pulumirpc.ResourceProvider/Create",
+			"2023-01-01T00:00:02Z", "2023-01-01T00:00:05Z", "", "", "aws:s3/bucket:Bucket", "a.trace"},
+		{"/pulumirpc.ResourceProvider/Update",
+			"2023-01-01T00:00:04Z", "2023-01-01T00:00:06Z", "", "", "aws:s3/bucket:Bucket", "a.trace"},
+		{"/pulumirpc.ResourceProvider/Check",
+			"2023-01-01T00:00:01Z", "2023-01-01T00:00:02Z", "", "", "gcp:storage:Bucket", "a.trace"},
+	})
+
+	var rows []map[string]string
+	sink := MetricsSinkFunc(func(data []map[string]string) error {
+		rows = data
+		return nil
+	})
+	require.NoError(t, MetricsWithSpec(csvFile, "filename", spec, sink))
+	require.Len(t, rows, 1)
+
+	m := rows[0]
+	assert.Equal(t, "aws", m["provider"])
+	assert.Equal(t, "3", m["rpc_count"])
+	assert.Equal(t, "5000", m["rpc_sum_ms"])
+	assert.Equal(t, "3000", m["rpc_max_ms"])
+	assert.Equal(t, "4000", m["aws_ms"])
+	assert.Equal(t, "1000", m["first_rpc_ms"])
+	assert.Equal(t, "2023-01-01T00:00:00Z", m[benchmark_start])
+}
+
+func TestInvalidMetricsSpec(t *testing.T) {
+	for _, spec := range []string{
+		`metrics: [{column: x, aggregate: median}]`,
+		`metrics: [{column: x, aggregate: value}]`,
+		`metrics: [{column: x, aggregate: sum, span: {name: a, prefix: b}}]`,
+		`metrics: [{column: x, aggregate: sum}, {column: x, aggregate: count}]`,
+		`metrics: [{column: x, aggregate: sum, spam: 1}]`,
+	} {
+		_, err := parseMetricsSpec([]byte(spec), false)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParquetMetricsSinkKeepsAllColumns(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "metrics.parquet")
+	sink := NewParquetFileMetricsSink(file)
+	require.NoError(t, sink.WriteMetrics([]map[string]string{{
+		benchmark_name:                   "a",
+		time_log_overhead_ms:             "",
+		time_resource_provider_create_ms: "1500",
+		"not_in_spec":                    "kept",
+	}}))
+
+	rows, err := readParquetMetrics(file)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, map[string]string{
+		benchmark_name:                   "a",
+		time_resource_provider_create_ms: "1500",
+		"not_in_spec":                    "kept",
+	}, rows[0])
+}
+
+func TestInferColumnType(t *testing.T) {
+	data := []map[string]string{
+		{"i": "1", "f": "1", "ts": "2023-01-01T00:00:00Z", "s": "x", "e": ""},
+		{"i": "", "f": "2.5", "ts": "2023-01-01T00:00:01.5Z", "s": "1"},
+	}
+	assert.Equal(t, int64ColumnType, inferColumnType("i", data))
+	assert.Equal(t, float64ColumnType, inferColumnType("f", data))
+	assert.Equal(t, timestampColumnType, inferColumnType("ts", data))
+	assert.Equal(t, stringColumnType, inferColumnType("s", data))
+	assert.Equal(t, stringColumnType, inferColumnType("e", data))
+}
+
+func TestToParquetArbitraryCsv(t *testing.T) {
+	csvFile := writeTestCsv(t, [][]string{
+		{"Name", "Span.Start", "ratio", "count", benchmark_start},
+		{"pulumi", "2023-01-01T00:00:00.25Z", "0.5", "3", "2023-01-01T00:00:00Z"},
+		{"pulumi-plan", "", "", "", ""},
+	})
+	out := filepath.Join(t.TempDir(), "traces.parquet")
+	require.NoError(t, ToParquet(csvFile, out))
+
+	rows, err := readParquetMetrics(out)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, map[string]string{
+		"Name":          "pulumi",
+		"Span.Start":    "2023-01-01T00:00:00.25Z",
+		"ratio":         "0.5",
+		"count":         "3",
+		benchmark_start: "2023-01-01T00:00:00Z",
+	}, rows[0])
+	// Empty values are null except in string columns, and
+	// benchmark_start is a string as declared by the default spec.
+	assert.Equal(t, map[string]string{
+		"Name":          "pulumi-plan",
+		benchmark_start: "",
+	}, rows[1])
+
+	schemaFile := filepath.Join(t.TempDir(), "schema.yaml")
+	require.NoError(t, os.WriteFile(schemaFile, []byte(`
+columns:
+  - {name: Name, type: string}
+  - {name: count, type: float64}
+`), 0o600))
+	schema, err := LoadParquetSchema(schemaFile)
+	require.NoError(t, err)
+	require.NoError(t, ToParquetWithSchema(csvFile, out, schema))
+
+	rows, err = readParquetMetrics(out)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Name": "pulumi", "count": "3"}, rows[0])
+
+	// Both formats reject unknown fields.
+	for name, data := range map[string]string{
+		"bad.yaml": "columns:\n  - {name: Name, type: string, nullable: true}\n",
+		"bad.json": `{"columns": [{"name": "Name", "type": "string", "nullable": true}]}`,
+	} {
+		badFile := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(badFile, []byte(data), 0o600))
+		_, err := LoadParquetSchema(badFile)
+		assert.ErrorContains(t, err, "nullable", name)
+	}
+}
@@ -0,0 +1,417 @@
+// Reads OpenTelemetry (OTLP) trace files and normalizes the spans into
+// an appdash MemoryStore, so that every command can consume them the
+// same way as `pulumi --tracing file:` output.
+
+package traces
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// A span decoded from either OTLP encoding, before it is converted
+// to appdash annotations.
+type otlpSpan struct {
+	traceID  []byte
+	spanID   []byte
+	parentID []byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    []appdash.Annotation
+	events   []otlpEvent
+}
+
+type otlpEvent struct {
+	name  string
+	time  time.Time
+	attrs []appdash.Annotation
+}
+
+// Reads OTLP JSON. Accepts a single `TracesData` (or
+// `ExportTraceServiceRequest`) object as well as a stream of them, as
+// written one per line by the OpenTelemetry Collector file exporter.
+func readOtlpJson(reader io.Reader, memStore *appdash.MemoryStore) error {
+	decoder := json.NewDecoder(reader)
+	for {
+		var data otlpJsonTracesData
+		err := decoder.Decode(&data)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to parse OTLP JSON: %w", err)
+		}
+
+		for _, rs := range data.ResourceSpans {
+			resAttrs := rs.Resource.Attributes.annotations()
+			scopes := append(rs.ScopeSpans, rs.InstrumentationLibrarySpans...)
+			for _, ss := range scopes {
+				for _, s := range ss.Spans {
+					span, err := s.otlpSpan()
+					if err != nil {
+						return err
+					}
+					if err := collectOtlpSpan(memStore, resAttrs, span); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+}
+
+// Reads a binary protobuf-encoded OTLP `TracesData` message. The
+// `ExportTraceServiceRequest` message has the same wire format.
+func readOtlpProto(reader io.Reader, memStore *appdash.MemoryStore) error {
+	buf, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	var data collectortrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(buf, &data); err != nil {
+		return fmt.Errorf("Failed to parse OTLP protobuf: %w", err)
+	}
+
+	for _, rs := range data.ResourceSpans {
+		resAttrs := otlpProtoAttributes(rs.GetResource().GetAttributes())
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				if err := collectOtlpSpan(memStore, resAttrs, otlpProtoSpan(s)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Records the span in the store using the same annotations that the
+// appdash OpenTracing recorder produces for Pulumi spans: a `Name`
+// event, a `Timespan` event, a `log` event per span event and one
+// annotation per attribute. Resource attributes are copied onto every
+// span that does not set them already. Log events only carry a message
+// and a time, so the other attributes of span events are dropped rather
+// than mixed with those of the span.
+func collectOtlpSpan(memStore *appdash.MemoryStore, resAttrs []appdash.Annotation, span otlpSpan) error {
+	id := appdash.SpanID{
+		Trace:  otlpID(span.traceID),
+		Span:   otlpID(span.spanID),
+		Parent: otlpID(span.parentID),
+	}
+
+	if id.Span == 0 {
+		return fmt.Errorf("OTLP span %q has no span ID", span.name)
+	}
+
+	var anns []appdash.Annotation
+
+	nameAnns, err := appdash.MarshalEvent(appdash.SpanName(span.name))
+	if err != nil {
+		return err
+	}
+	anns = append(anns, nameAnns...)
+
+	// Span events become log events; the conventional `message`
+	// attribute is preferred over the event name as the log message.
+	for _, e := range span.events {
+		msg := e.name
+		for _, a := range e.attrs {
+			if a.Key == "message" {
+				msg = string(a.Value)
+			}
+		}
+		logAnns, err := appdash.MarshalEvent(appdash.LogWithTimestamp(msg, e.time))
+		if err != nil {
+			return err
+		}
+		anns = append(anns, logAnns...)
+	}
+
+	seen := make(map[string]bool)
+	for _, a := range span.attrs {
+		seen[a.Key] = true
+		anns = append(anns, a)
+	}
+	for _, a := range resAttrs {
+		if !seen[a.Key] {
+			anns = append(anns, a)
+		}
+	}
+
+	timeAnns, err := appdash.MarshalEvent(appdash.Timespan{S: span.start, E: span.end})
+	if err != nil {
+		return err
+	}
+	anns = append(anns, timeAnns...)
+
+	return memStore.Collect(id, anns...)
+}
+
+// Folds an OTLP trace or span ID into a 64-bit appdash ID. Span IDs
+// are 8 bytes already; 16-byte trace IDs keep their low 64 bits, the
+// same convention Jaeger uses for 64-bit compatibility.
+func otlpID(b []byte) appdash.ID {
+	if len(b) > 8 {
+		b = b[len(b)-8:]
+	}
+	var buf [8]byte
+	copy(buf[8-len(b):], b)
+	return appdash.ID(binary.BigEndian.Uint64(buf[:]))
+}
+
+func unixNanoTime(ns uint64) time.Time {
+	return time.Unix(0, int64(ns)).UTC()
+}
+
+func otlpProtoSpan(s *tracepb.Span) otlpSpan {
+	span := otlpSpan{
+		traceID:  s.TraceId,
+		spanID:   s.SpanId,
+		parentID: s.ParentSpanId,
+		name:     s.Name,
+		start:    unixNanoTime(s.StartTimeUnixNano),
+		end:      unixNanoTime(s.EndTimeUnixNano),
+		attrs:    otlpProtoAttributes(s.Attributes),
+	}
+	for _, e := range s.Events {
+		span.events = append(span.events, otlpEvent{
+			name:  e.Name,
+			time:  unixNanoTime(e.TimeUnixNano),
+			attrs: otlpProtoAttributes(e.Attributes),
+		})
+	}
+	return span
+}
+
+func otlpProtoAttributes(kvs []*commonpb.KeyValue) []appdash.Annotation {
+	var anns []appdash.Annotation
+	for _, kv := range kvs {
+		anns = append(anns, appdash.Annotation{
+			Key:   kv.Key,
+			Value: []byte(otlpProtoValueString(kv.Value)),
+		})
+	}
+	return anns
+}
+
+func otlpProtoValueString(v *commonpb.AnyValue) string {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(x.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(x.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(x.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(x.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		var parts []string
+		for _, e := range x.ArrayValue.GetValues() {
+			parts = append(parts, otlpProtoValueString(e))
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	case *commonpb.AnyValue_KvlistValue:
+		var parts []string
+		for _, kv := range x.KvlistValue.GetValues() {
+			parts = append(parts, kv.Key+":"+otlpProtoValueString(kv.Value))
+		}
+		return "map[" + strings.Join(parts, " ") + "]"
+	default:
+		return ""
+	}
+}
+
+// OTLP JSON differs from the canonical protobuf JSON mapping: IDs are
+// hex-encoded and 64-bit integers may be strings or numbers, so the
+// messages are decoded with these hand-written types.
+
+type otlpJsonTracesData struct {
+	ResourceSpans []otlpJsonResourceSpans `json:"resourceSpans"`
+}
+
+type otlpJsonResourceSpans struct {
+	Resource struct {
+		Attributes otlpJsonAttributes `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans                  []otlpJsonScopeSpans `json:"scopeSpans"`
+	InstrumentationLibrarySpans []otlpJsonScopeSpans `json:"instrumentationLibrarySpans,omitempty"`
+}
+
+type otlpJsonScopeSpans struct {
+	Scope *otlpJsonScope `json:"scope,omitempty"`
+	Spans []otlpJsonSpan `json:"spans"`
+}
+
+type otlpJsonScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpJsonSpan struct {
+	TraceID           string             `json:"traceId"`
+	SpanID            string             `json:"spanId"`
+	ParentSpanID      string             `json:"parentSpanId,omitempty"`
+	Name              string             `json:"name"`
+	StartTimeUnixNano otlpJsonUint64     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   otlpJsonUint64     `json:"endTimeUnixNano"`
+	Attributes        otlpJsonAttributes `json:"attributes,omitempty"`
+	Events            []otlpJsonEvent    `json:"events,omitempty"`
+}
+
+type otlpJsonEvent struct {
+	TimeUnixNano otlpJsonUint64     `json:"timeUnixNano"`
+	Name         string             `json:"name"`
+	Attributes   otlpJsonAttributes `json:"attributes,omitempty"`
+}
+
+func (s otlpJsonSpan) otlpSpan() (otlpSpan, error) {
+	decodeID := func(field, value string) ([]byte, error) {
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse OTLP %s %q of span %q: %w", field, value, s.Name, err)
+		}
+		return b, nil
+	}
+
+	traceID, err := decodeID("traceId", s.TraceID)
+	if err != nil {
+		return otlpSpan{}, err
+	}
+	spanID, err := decodeID("spanId", s.SpanID)
+	if err != nil {
+		return otlpSpan{}, err
+	}
+	parentID, err := decodeID("parentSpanId", s.ParentSpanID)
+	if err != nil {
+		return otlpSpan{}, err
+	}
+
+	span := otlpSpan{
+		traceID:  traceID,
+		spanID:   spanID,
+		parentID: parentID,
+		name:     s.Name,
+		start:    unixNanoTime(uint64(s.StartTimeUnixNano)),
+		end:      unixNanoTime(uint64(s.EndTimeUnixNano)),
+		attrs:    s.Attributes.annotations(),
+	}
+	for _, e := range s.Events {
+		span.events = append(span.events, otlpEvent{
+			name:  e.Name,
+			time:  unixNanoTime(uint64(e.TimeUnixNano)),
+			attrs: e.Attributes.annotations(),
+		})
+	}
+	return span, nil
+}
+
+type otlpJsonAttributes []otlpJsonKeyValue
+
+type otlpJsonKeyValue struct {
+	Key   string        `json:"key"`
+	Value otlpJsonValue `json:"value"`
+}
+
+type otlpJsonValue struct {
+	StringValue *string        `json:"stringValue,omitempty"`
+	BoolValue   *bool          `json:"boolValue,omitempty"`
+	IntValue    *otlpJsonInt64 `json:"intValue,omitempty"`
+	DoubleValue *float64       `json:"doubleValue,omitempty"`
+	BytesValue  *string        `json:"bytesValue,omitempty"`
+	ArrayValue  *struct {
+		Values []otlpJsonValue `json:"values"`
+	} `json:"arrayValue,omitempty"`
+	KvlistValue *struct {
+		Values []otlpJsonKeyValue `json:"values"`
+	} `json:"kvlistValue,omitempty"`
+}
+
+func (attrs otlpJsonAttributes) annotations() []appdash.Annotation {
+	var anns []appdash.Annotation
+	for _, kv := range attrs {
+		anns = append(anns, appdash.Annotation{
+			Key:   kv.Key,
+			Value: []byte(kv.Value.String()),
+		})
+	}
+	return anns
+}
+
+func (v otlpJsonValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	case v.BytesValue != nil:
+		return *v.BytesValue
+	case v.ArrayValue != nil:
+		var parts []string
+		for _, e := range v.ArrayValue.Values {
+			parts = append(parts, e.String())
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	case v.KvlistValue != nil:
+		var parts []string
+		for _, kv := range v.KvlistValue.Values {
+			parts = append(parts, kv.Key+":"+kv.Value.String())
+		}
+		return "map[" + strings.Join(parts, " ") + "]"
+	default:
+		return ""
+	}
+}
+
+// 64-bit integers that OTLP JSON allows as either strings or numbers.
+// They are always written as strings.
+type otlpJsonUint64 uint64
+
+func (n otlpJsonUint64) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatUint(uint64(n), 10) + `"`), nil
+}
+
+func (n *otlpJsonUint64) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseUint(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = otlpJsonUint64(v)
+	return nil
+}
+
+type otlpJsonInt64 int64
+
+func (n otlpJsonInt64) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatInt(int64(n), 10) + `"`), nil
+}
+
+func (n *otlpJsonInt64) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = otlpJsonInt64(v)
+	return nil
+}
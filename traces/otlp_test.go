@@ -0,0 +1,190 @@
+package traces
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+const testOtlpJson = `{"resourceSpans": [{
+  "resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "pulumi"}}]},
+  "scopeSpans": [{"spans": [
+    {"traceId": "5b8efff798038103d269b633813fc60c", "spanId": "eee19b7ec3c1b174",
+     "name": "pulumi", "startTimeUnixNano": "1672531200000000000", "endTimeUnixNano": "1672531210000000000",
+     "attributes": [{"key": "runtime.NumCPU", "value": {"intValue": "8"}}],
+     "events": [{"name": "exception", "timeUnixNano": "1672531205000000000", "attributes": [
+       {"key": "message", "value": {"stringValue": "failed"}},
+       {"key": "exception.type", "value": {"stringValue": "error"}},
+       {"key": "runtime.NumCPU", "value": {"intValue": "1"}}]}]},
+    {"traceId": "5b8efff798038103d269b633813fc60c", "spanId": "eee19b7ec3c1b175",
+     "parentSpanId": "eee19b7ec3c1b174",
+     "name": "pulumi-plan", "startTimeUnixNano": 1672531201000000000, "endTimeUnixNano": 1672531209000000000}
+  ]}]
+}]}
+`
+
+func collectSpans(t *testing.T, file string) map[string]*appdash.Trace {
+	spans := make(map[string]*appdash.Trace)
+	require.NoError(t, walkTracesFromFile(file, func(tr *appdash.Trace) error {
+		spans[tr.Span.Name()] = tr
+		return nil
+	}))
+	return spans
+}
+
+func TestReadOtlpJson(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "up.json")
+	require.NoError(t, os.WriteFile(file, []byte(testOtlpJson), 0o600))
+
+	spans := collectSpans(t, file)
+	require.Len(t, spans, 2)
+
+	root := spans["pulumi"]
+	m := root.Span.Annotations.StringMap()
+	assert.Equal(t, "8", m["runtime.NumCPU"])
+	assert.Equal(t, "pulumi", m["service.name"])
+	assert.Equal(t, "2023-01-01T00:00:00Z", m["Span.Start"])
+	assert.Equal(t, "2023-01-01T00:00:10Z", m["Span.End"])
+
+	// Event attributes do not leak into the span's.
+	assert.Equal(t, "failed", m["Msg"])
+	assert.Equal(t, "2023-01-01T00:00:05Z", m["Time"])
+	assert.NotContains(t, m, "exception.type")
+	var numCPU []string
+	for _, a := range root.Span.Annotations {
+		if a.Key == "runtime.NumCPU" {
+			numCPU = append(numCPU, string(a.Value))
+		}
+	}
+	assert.Equal(t, []string{"8"}, numCPU)
+
+	require.Len(t, root.Sub, 1)
+	assert.Equal(t, "pulumi-plan", root.Sub[0].Span.Name())
+}
+
+func TestReadOtlpProto(t *testing.T) {
+	data := &tracepb.TracesData{
+		ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource: &resourcepb.Resource{},
+			ScopeSpans: []*tracepb.ScopeSpans{{
+				Spans: []*tracepb.Span{
+					{
+						TraceId:           []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+						SpanId:            []byte{0, 0, 0, 0, 0, 0, 0, 2},
+						Name:              "pulumi",
+						StartTimeUnixNano: 1672531200000000000,
+						EndTimeUnixNano:   1672531210000000000,
+						Attributes: []*commonpb.KeyValue{{
+							Key:   "benchmark_name",
+							Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "aws"}},
+						}},
+					},
+				},
+			}},
+		}},
+	}
+	buf, err := proto.Marshal(data)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "up.pb")
+	require.NoError(t, os.WriteFile(file, buf, 0o600))
+
+	spans := collectSpans(t, file)
+	require.Len(t, spans, 1)
+
+	root := spans["pulumi"]
+	assert.Equal(t, appdash.ID(1), root.Span.ID.Trace)
+	assert.Equal(t, appdash.ID(2), root.Span.ID.Span)
+	assert.Equal(t, "aws", root.Span.Annotations.StringMap()["benchmark_name"])
+}
+
+func TestToOtlpRoundTrip(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+	out := filepath.Join(t.TempDir(), "up.json")
+	require.NoError(t, ToOtlp(file, out))
+
+	spans := collectSpans(t, out)
+	require.Len(t, spans, 6)
+
+	root := spans["pulumi"]
+	m := root.Span.Annotations.StringMap()
+	assert.Equal(t, appdash.ID(1), root.Span.ID.Span)
+	assert.Equal(t, "test", m["benchmark_name"])
+	assert.Equal(t, "pulumi", m["service.name"])
+	assert.Equal(t, "2023-01-01T00:00:10Z", m["Span.End"])
+
+	log := spans["/pulumirpc.Engine/Log"]
+	assert.Equal(t, appdash.ID(2), log.Span.ID.Parent)
+	assert.Equal(t, "hello", log.Span.Annotations.StringMap()["Msg"])
+}
+
+func TestToOtlpUntimedSpan(t *testing.T) {
+	memStore := appdash.NewMemoryStore()
+	collectTestSpans(t, memStore, 1, []testSpan{
+		{id: 1, name: "pulumi", start: 0, end: 10000},
+		{id: 3, parent: 2, name: "pulumi-plan", start: 1000, end: 9000},
+	})
+	// A span between the two without times.
+	anns, err := appdash.MarshalEvent(appdash.SpanName("untimed"))
+	require.NoError(t, err)
+	require.NoError(t, memStore.Collect(appdash.SpanID{Trace: 1, Span: 2, Parent: 1}, anns...))
+	file := filepath.Join(t.TempDir(), "up.trace")
+	require.NoError(t, writeMemoryStore(file, memStore))
+
+	data, err := otlpTracesData(file)
+	require.NoError(t, err)
+	require.Len(t, data.ResourceSpans, 1)
+	spans := data.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "pulumi", spans[0].Name)
+	assert.Empty(t, spans[0].ParentSpanID)
+	assert.Equal(t, "pulumi-plan", spans[1].Name)
+	assert.Equal(t, spans[0].SpanID, spans[1].ParentSpanID)
+}
+
+func TestPushOtlp(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+
+	var requests []*http.Request
+	var bodies [][]byte
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		requests, bodies = append(requests, r), append(bodies, body)
+		w.WriteHeader(status)
+		_, err = w.Write([]byte("rejected"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	require.NoError(t, PushOtlp(file, server.URL+"/"))
+	require.Len(t, requests, 1)
+	assert.Equal(t, http.MethodPost, requests[0].Method)
+	assert.Equal(t, "/v1/traces", requests[0].URL.Path)
+	assert.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+
+	// The body reads back as the same spans as the exported file.
+	pushed := filepath.Join(t.TempDir(), "pushed.json")
+	require.NoError(t, os.WriteFile(pushed, bodies[0], 0o600))
+	spans := collectSpans(t, pushed)
+	require.Len(t, spans, 6)
+	assert.Equal(t, "pulumi", spans["pulumi"].Span.Annotations.StringMap()["service.name"])
+
+	status = http.StatusBadRequest
+	err := PushOtlp(file, server.URL+"/v1/traces")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 Bad Request: rejected")
+	assert.Equal(t, "/v1/traces", requests[1].URL.Path)
+}
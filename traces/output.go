@@ -0,0 +1,122 @@
+package traces
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats shared by the analysis commands.
+const (
+	tableOutputFormat = "table"
+	csvOutputFormat   = "csv"
+	jsonOutputFormat  = "json"
+)
+
+// Validates a `-format` flag value for writeRecords.
+func checkOutputFormat(format string) error {
+	switch format {
+	case tableOutputFormat, csvOutputFormat, jsonOutputFormat:
+		return nil
+	default:
+		return fmt.Errorf("Unknown output format %q, expected one of: %s, %s, %s",
+			format, tableOutputFormat, csvOutputFormat, jsonOutputFormat)
+	}
+}
+
+// Writes records as an aligned text table, CSV with a header row, or a
+// JSON array of objects. Columns fix the order of the table and CSV
+// columns; values missing from a record are left empty.
+func writeRecords(w io.Writer, format string, columns []string, records []map[string]interface{}) error {
+	if err := checkOutputFormat(format); err != nil {
+		return err
+	}
+
+	row := func(r map[string]interface{}) []string {
+		values := make([]string, len(columns))
+		for i, c := range columns {
+			if v, ok := r[c]; ok {
+				values[i] = formatValue(v)
+			}
+		}
+		return values
+	}
+
+	switch format {
+	case csvOutputFormat:
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.Write(columns); err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err := csvWriter.Write(row(r)); err != nil {
+				return err
+			}
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	case jsonOutputFormat:
+		if records == nil {
+			records = []map[string]interface{}{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(columns, "\t"))
+		for _, r := range records {
+			fmt.Fprintln(tw, strings.Join(row(r), "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+func formatValue(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("%v", x)
+	}
+}
+
+// Converts a duration to fractional milliseconds.
+func msFloat(dur time.Duration) float64 {
+	return float64(dur) / float64(time.Millisecond)
+}
+
+// Writes v as JSON to the file, or to stdout if the path is empty,
+// failing if the file cannot be flushed and closed.
+func writeJSONOutput(outputFile string, v interface{}) (err error) {
+	if outputFile == "" {
+		return json.NewEncoder(os.Stdout).Encode(v)
+	}
+
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return err
+	}
+	return w.Flush()
+}
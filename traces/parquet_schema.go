@@ -0,0 +1,143 @@
+package traces
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column types of Parquet files written by this tool. All columns are
+// nullable.
+const (
+	stringColumnType    = "string"
+	int64ColumnType     = "int64"
+	float64ColumnType   = "float64"
+	timestampColumnType = "timestamp"
+)
+
+// Columns of a Parquet file, loaded from YAML or JSON such as:
+//
+//	columns:
+//	  - {name: benchmark_name, type: string}
+//	  - {name: time_total_ms, type: int64}
+type ParquetSchema struct {
+	Columns []ParquetColumn `yaml:"columns" json:"columns"`
+}
+
+type ParquetColumn struct {
+	Name string `yaml:"name" json:"name"`
+
+	// One of string, int64, float64 or timestamp (RFC3339 values
+	// stored as microseconds since the epoch).
+	Type string `yaml:"type" json:"type"`
+}
+
+// Loads a Parquet schema from a YAML file, or from a JSON file if the
+// path ends in .json.
+func LoadParquetSchema(path string) (*ParquetSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema ParquetSchema
+	err = decodeSpec(data, strings.HasSuffix(path, ".json"), &schema)
+	if err == nil {
+		err = schema.check()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load Parquet schema %s: %w", path, err)
+	}
+	return &schema, nil
+}
+
+func (schema *ParquetSchema) check() error {
+	seen := make(map[string]bool)
+	for i, c := range schema.Columns {
+		if c.Name == "" {
+			return fmt.Errorf("column %d: missing name", i+1)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("duplicate column %q", c.Name)
+		}
+		seen[c.Name] = true
+		if err := checkColumnType(c.Type); err != nil {
+			return fmt.Errorf("column %q: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+func checkColumnType(typ string) error {
+	switch typ {
+	case stringColumnType, int64ColumnType, float64ColumnType, timestampColumnType:
+		return nil
+	default:
+		return fmt.Errorf("unknown type %q, expected one of: %s, %s, %s, %s", typ,
+			stringColumnType, int64ColumnType, float64ColumnType, timestampColumnType)
+	}
+}
+
+// Columns for the rows with types inferred from their values. The known
+// columns keep their types, others are added in the order they are
+// first seen.
+func inferParquetColumns(known []ParquetColumn, data []map[string]string) []ParquetColumn {
+	columns := append([]ParquetColumn{}, known...)
+	seen := make(map[string]bool)
+	for _, c := range known {
+		seen[c.Name] = true
+	}
+	for _, name := range metricsColumns(data) {
+		if !seen[name] {
+			columns = append(columns, ParquetColumn{name, inferColumnType(name, data)})
+		}
+	}
+	return columns
+}
+
+// The narrowest type that fits every non-empty value of the column:
+// int64, float64, timestamp, or string. Columns without values are
+// strings.
+func inferColumnType(column string, data []map[string]string) string {
+	isInt, isFloat, isTime := true, true, true
+	empty := true
+	for _, row := range data {
+		v := row[column]
+		if v == "" {
+			continue
+		}
+		empty = false
+		if isInt {
+			if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+				isInt = false
+			}
+		}
+		if isFloat {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				isFloat = false
+			}
+		}
+		if isTime {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				isTime = false
+			}
+		}
+		if !isInt && !isFloat && !isTime {
+			break
+		}
+	}
+	switch {
+	case empty:
+		return stringColumnType
+	case isInt:
+		return int64ColumnType
+	case isFloat:
+		return float64ColumnType
+	case isTime:
+		return timestampColumnType
+	default:
+		return stringColumnType
+	}
+}
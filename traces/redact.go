@@ -0,0 +1,178 @@
+// Rewrites the annotations of trace files so that they can be shared.
+
+package traces
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Actions of redact rules.
+const (
+	dropRedactAction    = "drop"
+	hashRedactAction    = "hash"
+	replaceRedactAction = "replace"
+)
+
+//go:embed default_redact.yaml
+var defaultRedactSpec []byte
+
+// Rules rewriting annotations, see default_redact.yaml for an example.
+type RedactSpec struct {
+	Rules []RedactRule `yaml:"rules" json:"rules"`
+}
+
+// Rewrites the annotations named Key, or matching KeyRegex.
+type RedactRule struct {
+	Key      string `yaml:"key,omitempty" json:"key,omitempty"`
+	KeyRegex string `yaml:"key_regex,omitempty" json:"key_regex,omitempty"`
+
+	// One of drop, hash or replace.
+	Action string `yaml:"action" json:"action"`
+
+	// What the replace action replaces.
+	Regex       string `yaml:"regex,omitempty" json:"regex,omitempty"`
+	Replacement string `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+
+	keyRegex *regexp.Regexp
+	regex    *regexp.Regexp
+}
+
+// The rules applied when no spec is given.
+func DefaultRedactSpec() *RedactSpec {
+	spec, err := parseRedactSpec(defaultRedactSpec, false)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid default redact spec: %v", err))
+	}
+	return spec
+}
+
+// Loads redact rules from a YAML file, or from a JSON file if the path
+// ends in .json.
+func LoadRedactSpec(path string) (*RedactSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := parseRedactSpec(data, strings.HasSuffix(path, ".json"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load redact rules %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+func parseRedactSpec(data []byte, isJSON bool) (*RedactSpec, error) {
+	var spec RedactSpec
+	if err := decodeSpec(data, isJSON, &spec); err != nil {
+		return nil, err
+	}
+	if err := spec.compile(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+func (spec *RedactSpec) compile() error {
+	for i := range spec.Rules {
+		r := &spec.Rules[i]
+		if (r.Key == "") == (r.KeyRegex == "") {
+			return fmt.Errorf("rule %d: expected one of key and key_regex", i+1)
+		}
+		if r.KeyRegex != "" {
+			re, err := regexp.Compile(r.KeyRegex)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			r.keyRegex = re
+		}
+		switch r.Action {
+		case dropRedactAction, hashRedactAction:
+		case replaceRedactAction:
+			if r.Regex == "" {
+				return fmt.Errorf("rule %d: the replace action needs a regex", i+1)
+			}
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			r.regex = re
+		default:
+			return fmt.Errorf("rule %d: unknown action %q", i+1, r.Action)
+		}
+	}
+	return nil
+}
+
+func (r *RedactRule) matches(key string) bool {
+	if r.keyRegex != nil {
+		return r.keyRegex.MatchString(key)
+	}
+	return r.Key == key
+}
+
+// Rewrites the annotations of every span of the input trace file by the
+// first matching rule and writes the result to the output trace file.
+// Span IDs, names and timings are kept. Hashes are salted with salt, if
+// given, so that short values cannot be guessed by hashing candidates.
+func Redact(inputFilePath, outputFilePath string, spec *RedactSpec, salt string) error {
+	traces, err := readTracesFromFile(inputFilePath)
+	if err != nil {
+		return err
+	}
+
+	newStore := appdash.NewMemoryStore()
+	err = walkTraces(traces, func(tr *appdash.Trace) error {
+		return newStore.Collect(tr.ID, spec.redact(tr.Annotations, salt)...)
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeMemoryStore(outputFilePath, newStore)
+}
+
+func (spec *RedactSpec) redact(anns appdash.Annotations, salt string) appdash.Annotations {
+	var res appdash.Annotations
+	for _, a := range anns {
+		if isEventAnnotation(a.Key) || a.Key == "Name" {
+			res = append(res, a)
+			continue
+		}
+
+		value, keep := spec.redactValue(a.Key, string(a.Value), salt)
+		if keep {
+			res = append(res, appdash.Annotation{Key: a.Key, Value: []byte(value)})
+		}
+	}
+	return res
+}
+
+// Returns the rewritten value and whether to keep the annotation.
+func (spec *RedactSpec) redactValue(key, value, salt string) (string, bool) {
+	for i := range spec.Rules {
+		r := &spec.Rules[i]
+		if !r.matches(key) {
+			continue
+		}
+		switch r.Action {
+		case dropRedactAction:
+			return "", false
+		case hashRedactAction:
+			if value == "" {
+				return value, true
+			}
+			sum := sha256.Sum256([]byte(salt + value))
+			return "sha256:" + hex.EncodeToString(sum[:8]), true
+		default:
+			return r.regex.ReplaceAllString(value, r.Replacement), true
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,80 @@
+package traces
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	spans := append(testPulumiSpans(),
+		testSpan{id: 8, parent: 1, name: "api/getStack", start: 100, end: 200, attrs: map[string]string{
+			"path": "/api/stacks/acme/website/prod/updates/latest",
+		}},
+		testSpan{id: 9, parent: 3, name: "register", start: 2100, end: 2200, attrs: map[string]string{
+			"urn":          "urn:pulumi:prod::website::aws:s3/bucket:Bucket::site",
+			"stack":        "prod",
+			"project_name": "website",
+		}})
+	input := writeTestTrace(t, "up.trace", spans)
+	output := filepath.Join(t.TempDir(), "redacted.trace")
+
+	require.NoError(t, Redact(input, output, DefaultRedactSpec(), ""))
+	redacted := collectSpans(t, output)
+	original := collectSpans(t, input)
+
+	root := redacted["pulumi"].Span.Annotations.StringMap()
+	assert.Regexp(t, "^sha256:[0-9a-f]{16}$", root["os.Args"])
+	assert.Equal(t, "test", root["benchmark_name"])
+	assert.Equal(t, original["pulumi"].Span.Annotations.StringMap()["Span.Start"], root["Span.Start"])
+	assert.Equal(t, original["pulumi"].Span.Annotations.StringMap()["Span.End"], root["Span.End"])
+
+	log := redacted["/pulumirpc.Engine/Log"].Span.Annotations.StringMap()
+	assert.NotEqual(t, "hello", log["Msg"])
+	assert.Equal(t, "2023-01-01T00:00:06Z", log["Time"])
+
+	assert.Equal(t, "/api/stacks/{org}/{project}/{stack}/updates/latest",
+		redacted["api/getStack"].Span.Annotations.StringMap()["path"])
+
+	register := redacted["register"].Span.Annotations.StringMap()
+	assert.Equal(t, "urn:pulumi:{stack}::{project}::aws:s3/bucket:Bucket::site", register["urn"])
+	assert.NotEqual(t, "prod", register["stack"])
+	assert.NotEqual(t, "website", register["project_name"])
+	assert.Equal(t, redacted["register"].Span.ID, original["register"].Span.ID)
+
+	// Salting changes the hashes.
+	require.NoError(t, Redact(input, output, DefaultRedactSpec(), "secret"))
+	salted := collectSpans(t, output)["pulumi"].Span.Annotations.StringMap()
+	assert.NotEqual(t, root["os.Args"], salted["os.Args"])
+
+	rules := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(rules, []byte(`rules:
+  - key: benchmark_name
+    action: drop
+  - key_regex: '^os\.'
+    action: replace
+    regex: 'up'
+    replacement: 'preview'
+`), 0o600))
+	spec, err := LoadRedactSpec(rules)
+	require.NoError(t, err)
+	require.NoError(t, Redact(input, output, spec, ""))
+	custom := collectSpans(t, output)["pulumi"].Span.Annotations.StringMap()
+	assert.NotContains(t, custom, "benchmark_name")
+	assert.Equal(t, "[pulumi preview --yes]", custom["os.Args"])
+	assert.Equal(t, "hello", collectSpans(t, output)["/pulumirpc.Engine/Log"].Span.Annotations.StringMap()["Msg"])
+
+	for _, invalid := range []string{
+		"rules: [{action: drop}]",
+		"rules: [{key: a, key_regex: b, action: drop}]",
+		"rules: [{key: a, action: replace}]",
+		"rules: [{key: a, action: shred}]",
+		"rules: [{key: a, action: drop, extra: 1}]",
+	} {
+		_, err := parseRedactSpec([]byte(invalid), false)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,362 @@
+// A self-contained HTML report over trace files, for sharing results
+// with people who do not run this tool.
+
+package traces
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+//go:embed report.html.tmpl
+var reportTemplate string
+
+type ReportOptions struct {
+	Title string
+
+	// Limits on the spans drawn per trace file, the spans listed by
+	// self time and the log messages shown; defaults apply if zero.
+	MaxSpans    int
+	MaxTopSpans int
+	MaxLogs     int
+}
+
+func (opts *ReportOptions) withDefaults() ReportOptions {
+	res := *opts
+	if res.Title == "" {
+		res.Title = "Pulumi performance report"
+	}
+	if res.MaxSpans <= 0 {
+		res.MaxSpans = 2000
+	}
+	if res.MaxTopSpans <= 0 {
+		res.MaxTopSpans = 25
+	}
+	if res.MaxLogs <= 0 {
+		res.MaxLogs = 1000
+	}
+	return res
+}
+
+type reportData struct {
+	Title     string
+	Generated string
+	Files     []string
+
+	SummaryColumns []string
+	SummaryRows    [][]string
+
+	Traces   []reportTrace
+	TopSpans []reportSpan
+
+	// The memory samples of every file, usually one per process, and
+	// charts of the statistics sampled several times.
+	MemoryColumns []string
+	MemoryRows    []reportMemoryRow
+	Memory        []reportMemoryChart
+
+	Logs        []LogEntry
+	OmittedLogs int
+}
+
+// The waterfall of the spans of a trace file.
+type reportTrace struct {
+	File     string
+	Duration string
+	Spans    []reportBar
+	Omitted  int
+}
+
+type reportBar struct {
+	Name     string
+	Depth    int
+	Left     string
+	Width    string
+	Duration string
+	Self     string
+}
+
+type reportSpan struct {
+	File     string
+	Name     string
+	Self     string
+	Duration string
+}
+
+// A memory sample (see MemSample), with a value per column of
+// reportData.MemoryColumns, empty if not recorded.
+type reportMemoryRow struct {
+	File    string
+	Process string
+	SpanEnd string
+	Values  []string
+}
+
+// A line chart of a memory statistic of a process over the run of a
+// trace file.
+type reportMemoryChart struct {
+	File    string
+	Process string
+	Key     string
+	Points  string
+	Min     string
+	Max     string
+}
+
+// Chart size in SVG user units.
+const (
+	reportChartWidth  = 600
+	reportChartHeight = 120
+)
+
+// Writes an HTML report on the trace files to the writer: the summary
+// metrics, a waterfall of the spans of every file, the spans with the
+// most self time, the memory statistics with charts of those sampled
+// several times, and the engine logs.
+// Styles are inline and nothing is loaded from the network.
+func Report(traceFiles []string, w io.Writer, opts ReportOptions) error {
+	opts = opts.withDefaults()
+	data := reportData{
+		Title:          opts.Title,
+		Generated:      time.Now().UTC().Format(time.RFC3339),
+		Files:          traceFiles,
+		SummaryColumns: summaryColumns,
+	}
+	for _, c := range memSampleAnnotations {
+		data.MemoryColumns = append(data.MemoryColumns, c.column)
+	}
+
+	rows, err := reportSummaryRows(traceFiles)
+	if err != nil {
+		return err
+	}
+	data.SummaryRows = rows
+
+	var top []reportSpanNode
+	for _, file := range traceFiles {
+		traces, err := readTracesFromFile(file)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %w", file, err)
+		}
+		roots := buildSpanTree(traces)
+
+		data.Traces = append(data.Traces, reportWaterfall(file, roots, opts.MaxSpans))
+		samples := memSamples(file, roots)
+		data.MemoryRows = append(data.MemoryRows, reportMemoryRows(samples)...)
+		data.Memory = append(data.Memory, reportMemoryCharts(file, samples)...)
+		err = walkSpanNodes(roots, func(n *spanNode) error {
+			if n.timed {
+				top = append(top, reportSpanNode{file, n})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].node.selfTime() > top[j].node.selfTime()
+	})
+	for i := 0; i < len(top) && i < opts.MaxTopSpans; i++ {
+		n := top[i].node
+		data.TopSpans = append(data.TopSpans, reportSpan{
+			File:     top[i].file,
+			Name:     n.name,
+			Self:     formatShortDuration(n.selfTime()),
+			Duration: formatShortDuration(n.duration()),
+		})
+	}
+
+	logs, err := ReadLogs(traceFiles, LogFilter{})
+	if err != nil {
+		return err
+	}
+	if len(logs) > opts.MaxLogs {
+		data.OmittedLogs = len(logs) - opts.MaxLogs
+		logs = logs[:opts.MaxLogs]
+	}
+	data.Logs = logs
+
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"base":   filepath.Base,
+		"indent": func(depth int) string { return strconv.Itoa(depth * 12) },
+	}).Parse(reportTemplate)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+type reportSpanNode struct {
+	file string
+	node *spanNode
+}
+
+// Computes the summary metrics (see Summary) of the trace files.
+func reportSummaryRows(traceFiles []string) ([][]string, error) {
+	dir, err := os.MkdirTemp("", "pulumi-trace-tool")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { noErr(os.RemoveAll(dir)) }()
+
+	csvFile := filepath.Join(dir, "traces.csv")
+	if err := ToCsv(traceFiles, csvFile, "filename"); err != nil {
+		return nil, fmt.Errorf("Failed converting trace files to CSV: %w", err)
+	}
+
+	var rows [][]string
+	err = Metrics(csvFile, "filename", MetricsSinkFunc(func(data []map[string]string) error {
+		for _, m := range data {
+			row := make([]string, len(summaryColumns))
+			for i, c := range summaryColumns {
+				row[i] = m[c]
+			}
+			rows = append(rows, row)
+		}
+		return nil
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compute metrics: %w", err)
+	}
+	return rows, nil
+}
+
+// Lays out the timed spans of a file in tree order on a timeline from
+// the earliest start to the latest end.
+func reportWaterfall(file string, roots []*spanNode, maxSpans int) reportTrace {
+	res := reportTrace{File: file}
+
+	var start, end time.Time
+	var timed []*spanNode
+	contract.IgnoreError(walkSpanNodes(roots, func(n *spanNode) error {
+		if n.timed {
+			start, end = widenTimespan(start, end, n.interval.Start, n.interval.End)
+			timed = append(timed, n)
+		}
+		return nil
+	}))
+	total := end.Sub(start)
+	res.Duration = formatShortDuration(total)
+	if total <= 0 {
+		return res
+	}
+
+	if len(timed) > maxSpans {
+		res.Omitted = len(timed) - maxSpans
+		timed = timed[:maxSpans]
+	}
+	percent := func(d time.Duration) string {
+		return strconv.FormatFloat(100*float64(d)/float64(total), 'f', 3, 64)
+	}
+	for _, n := range timed {
+		res.Spans = append(res.Spans, reportBar{
+			Name:     n.name,
+			Depth:    n.depth,
+			Left:     percent(n.interval.Start.Sub(start)),
+			Width:    percent(n.duration()),
+			Duration: formatShortDuration(n.duration()),
+			Self:     formatShortDuration(n.selfTime()),
+		})
+	}
+	return res
+}
+
+func reportMemoryRows(samples []MemSample) []reportMemoryRow {
+	rows := make([]reportMemoryRow, 0, len(samples))
+	for _, m := range samples {
+		row := reportMemoryRow{File: m.File, Process: m.Process, SpanEnd: formatLogTime(m.Time.UTC())}
+		for _, c := range memSampleAnnotations {
+			v := ""
+			if value, ok := m.Values[c.column]; ok {
+				v = strconv.FormatInt(value, 10)
+			}
+			row.Values = append(row.Values, v)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Charts every memory statistic of a process with at least two of the
+// samples of the file (see ReadMemSamples).
+func reportMemoryCharts(file string, samples []MemSample) []reportMemoryChart {
+	type point struct {
+		at    time.Time
+		value float64
+	}
+	type seriesKey struct {
+		process, column string
+	}
+	var keys []seriesKey
+	series := make(map[seriesKey][]point)
+	for _, m := range samples {
+		for _, c := range memSampleAnnotations {
+			v, ok := m.Values[c.column]
+			if !ok {
+				continue
+			}
+			k := seriesKey{m.Process, c.column}
+			if _, seen := series[k]; !seen {
+				keys = append(keys, k)
+			}
+			series[k] = append(series[k], point{m.Time, float64(v)})
+		}
+	}
+
+	var charts []reportMemoryChart
+	for _, k := range keys {
+		// Samples are ordered by time.
+		points := series[k]
+		if len(points) < 2 {
+			continue
+		}
+
+		first, last := points[0].at, points[len(points)-1].at
+		lo, hi := points[0].value, points[0].value
+		for _, p := range points {
+			if p.value < lo {
+				lo = p.value
+			}
+			if p.value > hi {
+				hi = p.value
+			}
+		}
+
+		var svg strings.Builder
+		for i, p := range points {
+			x, y := 0.0, float64(reportChartHeight)/2
+			if span := last.Sub(first); span > 0 {
+				x = reportChartWidth * float64(p.at.Sub(first)) / float64(span)
+			}
+			if hi > lo {
+				y = reportChartHeight * (1 - (p.value-lo)/(hi-lo))
+			}
+			if i > 0 {
+				svg.WriteString(" ")
+			}
+			fmt.Fprintf(&svg, "%.1f,%.1f", x, y)
+		}
+
+		charts = append(charts, reportMemoryChart{
+			File:    file,
+			Process: k.process,
+			Key:     k.column,
+			Points:  svg.String(),
+			Min:     strconv.FormatFloat(lo, 'f', -1, 64),
+			Max:     strconv.FormatFloat(hi, 'f', -1, 64),
+		})
+	}
+	return charts
+}
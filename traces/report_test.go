@@ -0,0 +1,69 @@
+package traces
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	spans := testPulumiSpans()
+	spans[0].attrs["MemStats.HeapAlloc"] = "1000"
+	spans[1].attrs = map[string]string{"MemStats.HeapAlloc": "3000"}
+	file := writeTestTrace(t, "up.trace", spans)
+
+	var buf bytes.Buffer
+	require.NoError(t, Report([]string{file}, &buf, ReportOptions{Title: "Nightly <run>", MaxTopSpans: 2}))
+	html := buf.String()
+
+	assert.Contains(t, html, "<title>Nightly &lt;run&gt;</title>")
+	assert.NotContains(t, html, "<script")
+	assert.NotContains(t, html, "http://")
+
+	// Summary row of the pulumi root span.
+	assert.Contains(t, html, "<td>test</td>")
+	assert.Contains(t, html, "<td>10000</td>")
+
+	// The two spans with the most self time.
+	assert.Contains(t, html, "<td>/pulumirpc.ResourceProvider/Create</td><td class=\"num\">3.50s</td>")
+	assert.Contains(t, html, "<td>/pulumirpc.ResourceMonitor/RegisterResource</td><td class=\"num\">3.00s</td>")
+	assert.NotContains(t, html, "<td>api/patchCheckpoint</td>")
+
+	// The Create span starts 40% into the trace and lasts 35% of it.
+	assert.Contains(t, html, "left: 40.000%; width: 35.000%")
+
+	assert.Contains(t, html, "<strong>heap_alloc</strong> <span class=\"meta\">pulumi in up.trace")
+	assert.Contains(t, html, "1000 to 3000")
+	assert.Contains(t, html, "<td>up.trace</td><td>pulumi</td><td>2023-01-01T00:00:09Z</td><td class=\"num\">3000</td>")
+	assert.Contains(t, html, "hello")
+}
+
+func TestReportWithoutLogsOrMemory(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", []testSpan{{id: 1, name: "pulumi", start: 0, end: 1000}})
+
+	var buf bytes.Buffer
+	require.NoError(t, Report([]string{file}, &buf, ReportOptions{}))
+	assert.Contains(t, buf.String(), "No engine log messages.")
+	assert.Contains(t, buf.String(), "No memory statistics recorded.")
+}
+
+func TestReportSingleMemorySample(t *testing.T) {
+	spans := testPulumiSpans()
+	spans[0].attrs["MemStats.HeapAlloc"] = "1000"
+	spans[0].attrs["MemStats.NumGC"] = "3"
+	file := writeTestTrace(t, "up.trace", spans)
+
+	var buf bytes.Buffer
+	require.NoError(t, Report([]string{file}, &buf, ReportOptions{}))
+	html := buf.String()
+
+	// Tabulated, though there is nothing to chart.
+	assert.NotContains(t, html, "No memory statistics recorded.")
+	assert.NotContains(t, html, "<polyline")
+	assert.Contains(t, html, "<th>heap_alloc</th>")
+	assert.Contains(t, html, "<td>up.trace</td><td>pulumi</td><td>2023-01-01T00:00:10Z</td>"+
+		"<td class=\"num\">1000</td><td class=\"num\"></td>")
+	assert.Contains(t, html, "<td class=\"num\">3</td>")
+}
@@ -0,0 +1,284 @@
+// Breaks down where time went per resource by correlating the resource
+// monitor and resource provider spans that mention the same URN.
+
+package traces
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-trace-tool/intervals"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Lifecycle phases of a resource and the spans that time them.
+const (
+	resourcePhaseRegister = "register"
+	resourcePhaseCheck    = "check"
+	resourcePhaseDiff     = "diff"
+	resourcePhaseCreate   = "create"
+	resourcePhaseUpdate   = "update"
+	resourcePhaseDelete   = "delete"
+	resourcePhaseRead     = "read"
+)
+
+var resourcePhases = []string{
+	resourcePhaseRegister,
+	resourcePhaseCheck,
+	resourcePhaseDiff,
+	resourcePhaseCreate,
+	resourcePhaseUpdate,
+	resourcePhaseDelete,
+	resourcePhaseRead,
+}
+
+func resourcePhaseSpans() map[string]string {
+	return map[string]string{
+		"/pulumirpc.ResourceMonitor/RegisterResource": resourcePhaseRegister,
+		"/pulumirpc.ResourceProvider/Check":           resourcePhaseCheck,
+		"/pulumirpc.ResourceProvider/Diff":            resourcePhaseDiff,
+		"/pulumirpc.ResourceProvider/Create":          resourcePhaseCreate,
+		"/pulumirpc.ResourceProvider/Update":          resourcePhaseUpdate,
+		"/pulumirpc.ResourceProvider/Delete":          resourcePhaseDelete,
+		"/pulumirpc.ResourceProvider/Read":            resourcePhaseRead,
+		"/pulumirpc.ResourceMonitor/ReadResource":     resourcePhaseRead,
+	}
+}
+
+// Time spent on a single resource in one trace file.
+type ResourceTiming struct {
+	File string
+	URN  string
+	Type string
+
+	// Time during which any span of a phase was running, by phase (see
+	// resourcePhases). The engine's client span and the provider's
+	// server span of a call count once.
+	Phases map[string]time.Duration
+
+	// Number of spans that contributed to the phases.
+	Spans int
+
+	// Time during which any span of the resource was running. As
+	// registration encloses the provider calls, this is usually the
+	// registration time.
+	Total time.Duration
+}
+
+// Sum of all phases except registration, which encloses the provider
+// calls made on behalf of the resource.
+func (r ResourceTiming) ProviderTime() time.Duration {
+	var total time.Duration
+	for phase, d := range r.Phases {
+		if phase != resourcePhaseRegister {
+			total += d
+		}
+	}
+	return total
+}
+
+// Prefix of the names of the spans of resource monitor calls, which
+// are made by the program rather than on behalf of a resource URN.
+const resourceMonitorSpanNamePrefix = "/pulumirpc.ResourceMonitor/"
+
+// Finds the URN a span is about from its logged gRPC payloads. Resource
+// monitor calls only learn the URN from the engine's response, while
+// their request names the parent; provider calls carry it at the top
+// level of their request.
+func spanURN(name string, anns appdash.Annotations) string {
+	kind := grpcRequestPayload
+	if strings.HasPrefix(name, resourceMonitorSpanNamePrefix) {
+		kind = grpcResponsePayload
+	}
+	urn, _ := spanGRPCPayload(anns, kind)["urn"].(string)
+	return urn
+}
+
+// Extracts the type token from a URN such as
+// `urn:pulumi:dev::proj::aws:s3/bucket:Bucket::my-bucket`; the last
+// component of a qualified parent$child type is the resource type.
+func urnType(urn string) string {
+	parts := strings.Split(urn, "::")
+	if len(parts) < 4 {
+		return ""
+	}
+	qualified := strings.Split(parts[2], "$")
+	return qualified[len(qualified)-1]
+}
+
+// Collects per-resource phase timings from the trace files. Spans that
+// cannot be tied to a URN (for example when payload logging was
+// disabled with PULUMI_TRACING_NO_PAYLOADS) are skipped, as there is no
+// telling which resource they belong to.
+func ResourceTimings(traceFiles []string) ([]ResourceTiming, error) {
+	phaseSpans := resourcePhaseSpans()
+
+	var timings []ResourceTiming
+
+	for _, f := range traceFiles {
+		index := make(map[string]int)
+		union := make(map[int]*intervals.TimeTracker)
+		phaseUnions := make(map[int]map[string]*intervals.TimeTracker)
+
+		err := walkTracesFromFile(f, func(t *appdash.Trace) error {
+			phase, ok := phaseSpans[t.Span.Name()]
+			if !ok {
+				return nil
+			}
+
+			iv, err := traceInterval(t)
+			if err != nil {
+				return nil
+			}
+
+			urn := spanURN(t.Span.Name(), t.Span.Annotations)
+			if urn == "" {
+				return nil
+			}
+
+			i, seen := index[urn]
+			if !seen {
+				typ := urnType(urn)
+				if typ == "" {
+					typ = t.Span.Annotations.StringMap()["pulumi-decorator"]
+				}
+				i = len(timings)
+				index[urn] = i
+				union[i] = &intervals.TimeTracker{}
+				phaseUnions[i] = make(map[string]*intervals.TimeTracker)
+				timings = append(timings, ResourceTiming{
+					File:   f,
+					URN:    urn,
+					Type:   typ,
+					Phases: make(map[string]time.Duration),
+				})
+			}
+
+			pt, ok := phaseUnions[i][phase]
+			if !ok {
+				pt = &intervals.TimeTracker{}
+				phaseUnions[i][phase] = pt
+			}
+			if err := pt.Track(iv); err != nil {
+				return err
+			}
+			timings[i].Spans++
+			return union[i].Track(iv)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i, tt := range union {
+			timings[i].Total = tt.TimeTaken()
+			for phase, pt := range phaseUnions[i] {
+				timings[i].Phases[phase] = pt.TimeTaken()
+			}
+		}
+	}
+
+	return timings, nil
+}
+
+// Writes per-resource timings of the trace files to w, slowest first by
+// total time (see ResourceTiming.Total). With byType, resources of the
+// same type are aggregated across all files instead, reporting the
+// count and total and mean phase times.
+func Resources(traceFiles []string, byType bool, format string, w io.Writer) error {
+	if err := checkOutputFormat(format); err != nil {
+		return err
+	}
+
+	timings, err := ResourceTimings(traceFiles)
+	if err != nil {
+		return err
+	}
+
+	if !byType {
+		sort.SliceStable(timings, func(i, j int) bool {
+			return timings[i].Total > timings[j].Total
+		})
+
+		columns := []string{"file", "urn", "type"}
+		for _, p := range resourcePhases {
+			columns = append(columns, p+"_ms")
+		}
+		columns = append(columns, "provider_ms", "total_ms", "spans")
+
+		var records []map[string]interface{}
+		for _, r := range timings {
+			rec := map[string]interface{}{
+				"file":        r.File,
+				"urn":         r.URN,
+				"type":        r.Type,
+				"provider_ms": msFloat(r.ProviderTime()),
+				"total_ms":    msFloat(r.Total),
+				"spans":       r.Spans,
+			}
+			for _, p := range resourcePhases {
+				rec[p+"_ms"] = msFloat(r.Phases[p])
+			}
+			records = append(records, rec)
+		}
+
+		return writeRecords(w, format, columns, records)
+	}
+
+	type typeTiming struct {
+		typ       string
+		resources int
+		phases    map[string]time.Duration
+		provider  time.Duration
+		total     time.Duration
+		max       time.Duration
+	}
+
+	var types []*typeTiming
+	index := make(map[string]*typeTiming)
+	for _, r := range timings {
+		tt, ok := index[r.Type]
+		if !ok {
+			tt = &typeTiming{typ: r.Type, phases: make(map[string]time.Duration)}
+			index[r.Type] = tt
+			types = append(types, tt)
+		}
+		tt.resources++
+		for p, d := range r.Phases {
+			tt.phases[p] += d
+		}
+		tt.provider += r.ProviderTime()
+		tt.total += r.Total
+		if r.Total > tt.max {
+			tt.max = r.Total
+		}
+	}
+
+	sort.SliceStable(types, func(i, j int) bool {
+		return types[i].total > types[j].total
+	})
+
+	columns := []string{"type", "resources"}
+	for _, p := range resourcePhases {
+		columns = append(columns, p+"_ms", p+"_mean_ms")
+	}
+	columns = append(columns, "provider_ms", "total_ms", "max_resource_ms")
+
+	var records []map[string]interface{}
+	for _, tt := range types {
+		rec := map[string]interface{}{
+			"type":            tt.typ,
+			"resources":       tt.resources,
+			"provider_ms":     msFloat(tt.provider),
+			"total_ms":        msFloat(tt.total),
+			"max_resource_ms": msFloat(tt.max),
+		}
+		for _, p := range resourcePhases {
+			rec[p+"_ms"] = msFloat(tt.phases[p])
+			rec[p+"_mean_ms"] = msFloat(tt.phases[p] / time.Duration(tt.resources))
+		}
+		records = append(records, rec)
+	}
+
+	return writeRecords(w, format, columns, records)
+}
@@ -0,0 +1,112 @@
+package traces
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testStackURN  = "urn:pulumi:dev::proj::pulumi:pulumi:Stack::proj-dev"
+	testBucketURN = "urn:pulumi:dev::proj::aws:s3/bucket:Bucket::"
+	testRandomURN = "urn:pulumi:dev::proj::random:index/randomString:RandomString::r"
+)
+
+// The payloads logged by a RegisterResource call of a resource under
+// the stack.
+func testRegisterLogs(typ, name string) []string {
+	return []string{
+		testGRPCPayload(grpcRequestPayload,
+			`{"type":"`+typ+`","name":"`+name+`","parent":"`+testStackURN+`","custom":true}`),
+		testGRPCPayload(grpcResponsePayload, `{"urn":"urn:pulumi:dev::proj::`+typ+`::`+name+`","id":"`+name+`"}`),
+	}
+}
+
+// The request payload logged by a provider call.
+func testProviderLogs(urn string) []string {
+	return []string{
+		testGRPCPayload(grpcRequestPayload, `{"urn":"`+urn+`","properties":{"parent":"`+testStackURN+`"}}`),
+	}
+}
+
+func testResourceSpans() []testSpan {
+	return []testSpan{
+		{id: 1, name: "pulumi", start: 0, end: 10000},
+		{id: 2, parent: 1, name: "/pulumirpc.ResourceMonitor/RegisterResource", start: 1000, end: 5000,
+			logs: testRegisterLogs("aws:s3/bucket:Bucket", "a")},
+		{id: 3, parent: 2, name: "/pulumirpc.ResourceProvider/Check", start: 1100, end: 1500,
+			logs: testProviderLogs(testBucketURN + "a")},
+		// The engine's client span and the provider's server span of a
+		// call.
+		{id: 4, parent: 2, name: "/pulumirpc.ResourceProvider/Create", start: 1600, end: 4500,
+			logs: testProviderLogs(testBucketURN + "a")},
+		{id: 9, parent: 4, name: "/pulumirpc.ResourceProvider/Create", start: 1700, end: 4400,
+			logs: testProviderLogs(testBucketURN + "a")},
+		{id: 5, parent: 1, name: "/pulumirpc.ResourceMonitor/RegisterResource", start: 2000, end: 3000,
+			logs: testRegisterLogs("aws:s3/bucket:Bucket", "b")},
+		{id: 6, parent: 1, name: "/pulumirpc.ResourceMonitor/RegisterResource", start: 500, end: 1000,
+			logs: testRegisterLogs("random:index/randomString:RandomString", "r")},
+		// Without payloads there is no telling which resource this is.
+		{id: 7, parent: 1, name: "/pulumirpc.ResourceProvider/Create", start: 6000, end: 9000,
+			attrs: map[string]string{"pulumi-decorator": "aws:s3/bucket:Bucket"}},
+		// A failed registration only names its parent.
+		{id: 8, parent: 1, name: "/pulumirpc.ResourceMonitor/RegisterResource", start: 6000, end: 9000,
+			logs: testRegisterLogs("aws:s3/bucket:Bucket", "c")[:1]},
+	}
+}
+
+func TestResources(t *testing.T) {
+	a := writeTestTrace(t, "a.trace", testResourceSpans())
+
+	var buf bytes.Buffer
+	require.NoError(t, Resources([]string{a}, false, jsonOutputFormat, &buf))
+	var rows []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rows))
+	require.Len(t, rows, 3)
+
+	// Provider calls are enclosed by the registration and count once,
+	// as do the client and server spans of a call.
+	assert.Equal(t, testBucketURN+"a", rows[0]["urn"])
+	assert.Equal(t, "aws:s3/bucket:Bucket", rows[0]["type"])
+	assert.Equal(t, 4000.0, rows[0]["register_ms"])
+	assert.Equal(t, 400.0, rows[0]["check_ms"])
+	assert.Equal(t, 2900.0, rows[0]["create_ms"])
+	assert.Equal(t, 3300.0, rows[0]["provider_ms"])
+	assert.Equal(t, 4000.0, rows[0]["total_ms"])
+	assert.Equal(t, 4.0, rows[0]["spans"])
+
+	assert.Equal(t, testBucketURN+"b", rows[1]["urn"])
+	assert.Equal(t, 1000.0, rows[1]["total_ms"])
+	assert.Equal(t, testRandomURN, rows[2]["urn"])
+	assert.Equal(t, "random:index/randomString:RandomString", rows[2]["type"])
+	assert.Equal(t, 500.0, rows[2]["total_ms"])
+}
+
+func TestResourcesByType(t *testing.T) {
+	a := writeTestTrace(t, "a.trace", testResourceSpans())
+	b := writeTestTrace(t, "b.trace", testResourceSpans())
+
+	var buf bytes.Buffer
+	require.NoError(t, Resources([]string{a, b}, true, jsonOutputFormat, &buf))
+	var rows []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rows))
+	require.Len(t, rows, 2)
+
+	bucket := rows[0]
+	assert.Equal(t, "aws:s3/bucket:Bucket", bucket["type"])
+	assert.Equal(t, 4.0, bucket["resources"])
+	assert.Equal(t, 10000.0, bucket["register_ms"])
+	assert.Equal(t, 2500.0, bucket["register_mean_ms"])
+	assert.Equal(t, 5800.0, bucket["create_ms"])
+	assert.Equal(t, 1450.0, bucket["create_mean_ms"])
+	assert.Equal(t, 10000.0, bucket["total_ms"])
+	assert.Equal(t, 4000.0, bucket["max_resource_ms"])
+
+	random := rows[1]
+	assert.Equal(t, "random:index/randomString:RandomString", random["type"])
+	assert.Equal(t, 2.0, random["resources"])
+	assert.Equal(t, 1000.0, random["total_ms"])
+}
@@ -0,0 +1,469 @@
+// A small expression language for selecting spans, for example:
+//
+//	name =~ "^/pulumirpc.ResourceProvider/" && duration > 1s
+//	ann["pulumi-decorator"] =~ "^aws:" || ancestor(name == "pulumi-plan")
+//	!has("Msg") && depth <= 2
+//
+// Fields:
+//
+//	name       span name (string)
+//	ann["k"]   value of annotation k, empty if missing (string)
+//	duration   span duration (duration)
+//	self       duration minus the time covered by children (duration)
+//	depth      depth in the trace tree, 0 for roots (number)
+//	children   number of child spans (number)
+//
+// Strings compare with ==, != and the regular expression matches =~
+// and !~; if both sides are numbers, strings also compare with <, <=,
+// > and >=. Durations such as 1s, 250ms or 1m30s and numbers compare
+// with ==, !=, <, <=, > and >=. Spans without timing match no duration
+// comparison.
+//
+// Functions:
+//
+//	has("k")          the span has annotation k
+//	parent(expr)      the parent span matches expr
+//	ancestor(expr)    some ancestor span matches expr
+//	child(expr)       some child span matches expr
+//	descendant(expr)  some descendant span matches expr
+//
+// Expressions combine with !, && and || and group with parentheses.
+
+package traces
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A parsed span query, see ParseSpanQuery.
+type SpanQuery struct {
+	source string
+	match  spanPredicate
+}
+
+type spanPredicate func(n *spanNode) bool
+
+// Parses a span query expression.
+func ParseSpanQuery(source string) (*SpanQuery, error) {
+	tokens, err := lexSpanQuery(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &spanQueryParser{tokens: tokens}
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid span query %q: %w", source, err)
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("Invalid span query %q: unexpected %s", source, t)
+	}
+
+	return &SpanQuery{source: source, match: match}, nil
+}
+
+func (q *SpanQuery) String() string {
+	return q.source
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenDuration
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+
+	number   float64
+	duration time.Duration
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q at %d", t.text, t.pos+1)
+}
+
+// Operators, longest first so that they lex greedily.
+var spanQueryOps = []string{"&&", "||", "==", "!=", "=~", "!~", "<=", ">=", "<", ">", "!", "(", ")", "[", "]"}
+
+func lexSpanQuery(source string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(source) {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(source) && source[j] != '"' {
+				if source[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(source) {
+				return nil, fmt.Errorf("Unterminated string at %d in span query", i+1)
+			}
+			text := source[i : j+1]
+			s, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid string %s at %d in span query: %w", text, i+1, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s, pos: i})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(source) && (isDigitOrDot(source[j]) || isLetter(source[j]) || source[j] == 0xc2 || source[j] == 0xb5) {
+				j++
+			}
+			text := source[i:j]
+			if n, err := strconv.ParseFloat(text, 64); err == nil {
+				tokens = append(tokens, token{kind: tokenNumber, text: text, pos: i, number: n})
+			} else if d, err := time.ParseDuration(text); err == nil {
+				tokens = append(tokens, token{kind: tokenDuration, text: text, pos: i, duration: d})
+			} else {
+				return nil, fmt.Errorf("Invalid number or duration %q at %d in span query", text, i+1)
+			}
+			i = j
+		case isLetter(c) || c == '_':
+			j := i
+			for j < len(source) && (isLetter(source[j]) || source[j] == '_' || isDigitOrDot(source[j])) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range spanQueryOps {
+				if strings.HasPrefix(source[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("Unexpected character %q at %d in span query", c, i+1)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+func isDigitOrDot(c byte) bool {
+	return c == '.' || (c >= '0' && c <= '9')
+}
+
+func isLetter(c byte) bool {
+	return c < unicode.MaxASCII && unicode.IsLetter(rune(c))
+}
+
+type spanQueryParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *spanQueryParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *spanQueryParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *spanQueryParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokenOp && t.text == op
+}
+
+func (p *spanQueryParser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return fmt.Errorf("expected %q, got %s", op, p.peek())
+	}
+	p.next()
+	return nil
+}
+
+func (p *spanQueryParser) parseOr() (spanPredicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(n *spanNode) bool { return l(n) || right(n) }
+	}
+	return left, nil
+}
+
+func (p *spanQueryParser) parseAnd() (spanPredicate, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(n *spanNode) bool { return l(n) && right(n) }
+	}
+	return left, nil
+}
+
+func (p *spanQueryParser) parseUnary() (spanPredicate, error) {
+	if p.isOp("!") {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(n *spanNode) bool { return !inner(n) }, nil
+	}
+	if p.isOp("(") {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expectOp(")")
+	}
+
+	t := p.next()
+	if t.kind != tokenIdent {
+		return nil, fmt.Errorf("expected a field or function, got %s", t)
+	}
+
+	switch t.text {
+	case "has":
+		return p.parseHas()
+	case "parent", "ancestor", "child", "descendant":
+		return p.parseRelative(t.text)
+	case "name":
+		return p.parseStringComparison(func(n *spanNode) string { return n.name })
+	case "ann":
+		if err := p.expectOp("["); err != nil {
+			return nil, err
+		}
+		key := p.next()
+		if key.kind != tokenString {
+			return nil, fmt.Errorf("expected an annotation name string, got %s", key)
+		}
+		if err := p.expectOp("]"); err != nil {
+			return nil, err
+		}
+		return p.parseStringComparison(func(n *spanNode) string {
+			return spanAnnotation(n, key.text)
+		})
+	case "duration":
+		return p.parseDurationComparison(func(n *spanNode) time.Duration { return n.duration() })
+	case "self":
+		return p.parseDurationComparison(func(n *spanNode) time.Duration { return n.selfTime() })
+	case "depth":
+		return p.parseNumberComparison(func(n *spanNode) float64 { return float64(n.depth) })
+	case "children":
+		return p.parseNumberComparison(func(n *spanNode) float64 { return float64(len(n.children)) })
+	default:
+		return nil, fmt.Errorf("unknown field or function %s", t)
+	}
+}
+
+func (p *spanQueryParser) parseHas() (spanPredicate, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	key := p.next()
+	if key.kind != tokenString {
+		return nil, fmt.Errorf("expected an annotation name string, got %s", key)
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return func(n *spanNode) bool {
+		for _, a := range n.trace.Span.Annotations {
+			if a.Key == key.text {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func (p *spanQueryParser) parseRelative(relation string) (spanPredicate, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	inner, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+
+	switch relation {
+	case "parent":
+		return func(n *spanNode) bool {
+			return n.parent != nil && inner(n.parent)
+		}, nil
+	case "ancestor":
+		return func(n *spanNode) bool {
+			for a := n.parent; a != nil; a = a.parent {
+				if inner(a) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "child":
+		return func(n *spanNode) bool {
+			for _, c := range n.children {
+				if inner(c) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	default:
+		var anyDescendant func(n *spanNode) bool
+		anyDescendant = func(n *spanNode) bool {
+			for _, c := range n.children {
+				if inner(c) || anyDescendant(c) {
+					return true
+				}
+			}
+			return false
+		}
+		return anyDescendant, nil
+	}
+}
+
+func (p *spanQueryParser) comparisonOp(allowed ...string) (token, error) {
+	t := p.next()
+	if t.kind == tokenOp {
+		for _, op := range allowed {
+			if t.text == op {
+				return t, nil
+			}
+		}
+	}
+	return t, fmt.Errorf("expected one of %s, got %s", strings.Join(allowed, " "), t)
+}
+
+func (p *spanQueryParser) parseStringComparison(value func(n *spanNode) string) (spanPredicate, error) {
+	op, err := p.comparisonOp("==", "!=", "=~", "!~", "<", "<=", ">", ">=")
+	if err != nil {
+		return nil, err
+	}
+	lit := p.next()
+
+	switch op.text {
+	case "=~", "!~":
+		if lit.kind != tokenString {
+			return nil, fmt.Errorf("expected a regular expression string, got %s", lit)
+		}
+		re, err := regexp.Compile(lit.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s: %w", lit, err)
+		}
+		negate := op.text == "!~"
+		return func(n *spanNode) bool { return re.MatchString(value(n)) != negate }, nil
+	case "==", "!=":
+		if lit.kind != tokenString && lit.kind != tokenNumber {
+			return nil, fmt.Errorf("expected a string, got %s", lit)
+		}
+		negate := op.text == "!="
+		return func(n *spanNode) bool { return (value(n) == lit.text) != negate }, nil
+	default:
+		if lit.kind != tokenNumber {
+			return nil, fmt.Errorf("expected a number, got %s", lit)
+		}
+		return func(n *spanNode) bool {
+			x, err := strconv.ParseFloat(value(n), 64)
+			return err == nil && compareNumbers(op.text, x, lit.number)
+		}, nil
+	}
+}
+
+func (p *spanQueryParser) parseDurationComparison(value func(n *spanNode) time.Duration) (spanPredicate, error) {
+	op, err := p.comparisonOp("==", "!=", "<", "<=", ">", ">=")
+	if err != nil {
+		return nil, err
+	}
+	lit := p.next()
+	if lit.kind != tokenDuration && !(lit.kind == tokenNumber && lit.number == 0) {
+		return nil, fmt.Errorf("expected a duration such as 1s or 250ms, got %s", lit)
+	}
+	return func(n *spanNode) bool {
+		return n.timed && compareNumbers(op.text, float64(value(n)), float64(lit.duration))
+	}, nil
+}
+
+func (p *spanQueryParser) parseNumberComparison(value func(n *spanNode) float64) (spanPredicate, error) {
+	op, err := p.comparisonOp("==", "!=", "<", "<=", ">", ">=")
+	if err != nil {
+		return nil, err
+	}
+	lit := p.next()
+	if lit.kind != tokenNumber {
+		return nil, fmt.Errorf("expected a number, got %s", lit)
+	}
+	return func(n *spanNode) bool {
+		return compareNumbers(op.text, value(n), lit.number)
+	}, nil
+}
+
+func compareNumbers(op string, x, y float64) bool {
+	switch op {
+	case "==":
+		return x == y
+	case "!=":
+		return x != y
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	default:
+		return x >= y
+	}
+}
+
+// Value of the last annotation with the key, or empty.
+func spanAnnotation(n *spanNode, key string) string {
+	value := ""
+	for _, a := range n.trace.Span.Annotations {
+		if a.Key == key {
+			value = string(a.Value)
+		}
+	}
+	return value
+}
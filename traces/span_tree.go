@@ -0,0 +1,102 @@
+package traces
+
+import (
+	"time"
+
+	"github.com/pulumi/pulumi-trace-tool/intervals"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// A span of an appdash trace tree with its timing parsed, for analyses
+// that need to look at spans relative to their parents and children.
+type spanNode struct {
+	trace    *appdash.Trace
+	name     string
+	interval intervals.Interval
+
+	// False if the span has no valid `Span.Start` and `Span.End`.
+	timed bool
+
+	depth    int
+	parent   *spanNode
+	children []*spanNode
+
+	// See selfTime; computed once the children are built.
+	self time.Duration
+}
+
+// Builds span trees from appdash traces. Roots and children are ordered
+// by start time.
+func buildSpanTree(traces []*appdash.Trace) []*spanNode {
+	var build func(t *appdash.Trace, parent *spanNode) *spanNode
+	build = func(t *appdash.Trace, parent *spanNode) *spanNode {
+		n := &spanNode{trace: t, name: t.Span.Name(), parent: parent}
+		if parent != nil {
+			n.depth = parent.depth + 1
+		}
+		if iv, err := traceInterval(t); err == nil {
+			n.interval = iv
+			n.timed = true
+		}
+		for _, sub := range sortTracesByStart(t.Sub) {
+			n.children = append(n.children, build(sub, n))
+		}
+		n.self = n.computeSelfTime()
+		return n
+	}
+
+	var roots []*spanNode
+	for _, t := range sortTracesByStart(traces) {
+		roots = append(roots, build(t, nil))
+	}
+	return roots
+}
+
+func walkSpanNodes(nodes []*spanNode, onNode func(n *spanNode) error) error {
+	for _, n := range nodes {
+		if err := onNode(n); err != nil {
+			return err
+		}
+		if err := walkSpanNodes(n.children, onNode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *spanNode) duration() time.Duration {
+	if !n.timed {
+		return 0
+	}
+	return n.interval.End.Sub(n.interval.Start)
+}
+
+// Time spent in the span itself: its duration minus the union of the
+// time its children were running, clipped to the span.
+func (n *spanNode) selfTime() time.Duration {
+	return n.self
+}
+
+func (n *spanNode) computeSelfTime() time.Duration {
+	if !n.timed {
+		return 0
+	}
+	var children []intervals.Interval
+	for _, c := range n.children {
+		if !c.timed {
+			continue
+		}
+		iv := c.interval
+		if iv.Start.Before(n.interval.Start) {
+			iv.Start = n.interval.Start
+		}
+		if iv.End.After(n.interval.End) {
+			iv.End = n.interval.End
+		}
+		if iv.End.Before(iv.Start) {
+			continue
+		}
+		children = append(children, iv)
+	}
+	return n.duration() - intervals.UnionTime(children)
+}
@@ -0,0 +1,34 @@
+package traces
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func TestSpanTreeSelfTime(t *testing.T) {
+	spans := []testSpan{
+		{id: 1, name: "pulumi", start: 0, end: 10000},
+		// Clipped to the parent.
+		{id: 2, parent: 1, name: "late", start: 9000, end: 12000},
+	}
+	// Many overlapping children count once.
+	for i := 0; i < 2000; i++ {
+		spans = append(spans, testSpan{id: uint64(10 + i), parent: 1, name: "child", start: 1000 + i, end: 3000 + i})
+	}
+	memStore := appdash.NewMemoryStore()
+	collectTestSpans(t, memStore, 1, spans)
+	traces, err := memStore.Traces(appdash.TracesOpts{})
+	require.NoError(t, err)
+
+	roots := buildSpanTree(traces)
+	require.Len(t, roots, 1)
+	root := roots[0]
+	require.Len(t, root.children, 2001)
+	// Children run from 1s to 4.999s and from 9s to the end at 10s.
+	assert.Equal(t, 5001*time.Millisecond, root.selfTime())
+	assert.Equal(t, 2000*time.Millisecond, root.children[0].selfTime())
+}
@@ -0,0 +1,208 @@
+package traces
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Ways to group spans into the files written by Split.
+const (
+	SplitByTrace   = "trace"
+	SplitByProcess = "process"
+	SplitByWindow  = "window"
+)
+
+// Used when SplitOptions.Template is empty.
+const DefaultSplitTemplate = "{{.Input}}-{{.Key}}.trace"
+
+// Key of the group of the spans without timing when splitting by time
+// window.
+const untimedSplitKey = "untimed"
+
+type SplitOptions struct {
+	// One of SplitByTrace, SplitByProcess or SplitByWindow.
+	By string
+
+	// Length of the time windows, counted from the earliest span start.
+	Window time.Duration
+
+	// text/template for the output file names, given the input file name
+	// without its extensions as .Input, the group as .Key and its
+	// position in the input as .Index. Outputs are compressed by
+	// extension as with CreateOutputFile.
+	Template string
+}
+
+// The fields available to SplitOptions.Template.
+type splitFileName struct {
+	Input string
+	Key   string
+	Index int
+}
+
+// A group of spans written to one file.
+type splitGroup struct {
+	key   string
+	nodes []*spanNode
+}
+
+// Writes the spans of the trace file into one appdash file per root
+// trace, per pulumi_process or per time window, returning the written
+// files. A span whose parent ends up in another file becomes the root
+// of a new trace, so that every output is a valid trace file on its own.
+func Split(inputFile string, opts SplitOptions) ([]string, error) {
+	tmpl, err := template.New("split").Option("missingkey=error").Parse(orDefault(opts.Template, DefaultSplitTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid file name template: %w", err)
+	}
+
+	traces, err := readTracesFromFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := buildSpanTree(traces)
+
+	groupKey, err := splitGroupKey(opts, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[*spanNode]string)
+	groups := make(map[string]*splitGroup)
+	var ordered []*splitGroup
+	err = walkSpanNodes(roots, func(n *spanNode) error {
+		parentKey := ""
+		if n.parent != nil {
+			parentKey = keys[n.parent]
+		}
+		key := groupKey(n, parentKey)
+		keys[n] = key
+
+		g, ok := groups[key]
+		if !ok {
+			g = &splitGroup{key: key}
+			groups[key] = g
+			ordered = append(ordered, g)
+		}
+		g.nodes = append(g.nodes, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	input := trimTraceFileExtensions(filepath.Base(inputFile))
+	written := make(map[string]string)
+	var files []string
+	for i, g := range ordered {
+		var name bytes.Buffer
+		err := tmpl.Execute(&name, splitFileName{Input: input, Key: sanitizeFileNamePart(g.key), Index: i})
+		if err != nil {
+			return nil, fmt.Errorf("Invalid file name template: %w", err)
+		}
+		file := name.String()
+		if other, ok := written[file]; ok {
+			return nil, fmt.Errorf("The file name template gives %s for both %s and %s", file, other, g.key)
+		}
+		written[file] = g.key
+
+		if err := writeSplitGroup(file, g, keys); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Returns the function assigning spans to groups. Spans without a key
+// of their own follow their parent.
+func splitGroupKey(opts SplitOptions, roots []*spanNode) (func(n *spanNode, parentKey string) string, error) {
+	switch opts.By {
+	case SplitByTrace:
+		return func(n *spanNode, parentKey string) string {
+			if parentKey != "" {
+				return parentKey
+			}
+			return n.trace.Span.ID.Trace.String()
+		}, nil
+	case SplitByProcess:
+		return func(n *spanNode, parentKey string) string {
+			return spanProcessName(n.trace, parentKey)
+		}, nil
+	case SplitByWindow:
+		if opts.Window <= 0 {
+			return nil, fmt.Errorf("Splitting by time window needs a positive window")
+		}
+		first := earliestSpanStart(roots)
+		return func(n *spanNode, parentKey string) string {
+			if !n.timed {
+				return orDefault(parentKey, untimedSplitKey)
+			}
+			window := first.Add(n.interval.Start.Sub(first) / opts.Window * opts.Window)
+			return window.UTC().Format("20060102T150405.000Z")
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown split %q, expected one of %s, %s or %s",
+			opts.By, SplitByTrace, SplitByProcess, SplitByWindow)
+	}
+}
+
+func earliestSpanStart(roots []*spanNode) time.Time {
+	var first time.Time
+	contract.IgnoreError(walkSpanNodes(roots, func(n *spanNode) error {
+		if n.timed && (first.IsZero() || n.interval.Start.Before(first)) {
+			first = n.interval.Start
+		}
+		return nil
+	}))
+	return first
+}
+
+// Collects the spans of a group, parents first. Spans whose parent is in
+// another group start a trace of their own, with their span ID as the
+// trace ID.
+func writeSplitGroup(file string, g *splitGroup, keys map[*spanNode]string) error {
+	store := appdash.NewMemoryStore()
+	traceIDs := make(map[*spanNode]appdash.ID)
+	for _, n := range g.nodes {
+		id := n.trace.Span.ID
+		switch {
+		case n.parent == nil:
+		case keys[n.parent] != g.key:
+			id.Trace, id.Parent = id.Span, 0
+		default:
+			id.Trace = traceIDs[n.parent]
+		}
+		traceIDs[n] = id.Trace
+		if err := store.Collect(id, n.trace.Annotations...); err != nil {
+			return err
+		}
+	}
+	return writeMemoryStore(file, store)
+}
+
+// Replaces the characters that are unsafe in file names.
+func sanitizeFileNamePart(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package traces
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func splitSpanNames(t *testing.T, file string) []string {
+	var names []string
+	require.NoError(t, walkTracesFromFile(file, func(tr *appdash.Trace) error {
+		names = append(names, tr.Span.Name())
+		return nil
+	}))
+	sort.Strings(names)
+	return names
+}
+
+func TestSplit(t *testing.T) {
+	spans := append(testPulumiSpans(),
+		testSpan{id: 8, parent: 5, name: "pulumi-resource-aws", start: 4100, end: 7400},
+		testSpan{id: 9, parent: 8, name: "/pulumirpc.ResourceProvider/Create", start: 4200, end: 7300})
+	memStore := appdash.NewMemoryStore()
+	collectTestSpans(t, memStore, 1, spans)
+	collectTestSpans(t, memStore, 2, []testSpan{{id: 2, name: "pulumi", start: 20000, end: 21000}})
+	input := filepath.Join(t.TempDir(), "up.trace.gz")
+	require.NoError(t, writeMemoryStore(input, memStore))
+
+	dir := t.TempDir()
+	template := filepath.Join(dir, "{{.By}}")
+
+	_, err := Split(input, SplitOptions{By: SplitByTrace, Template: template})
+	assert.ErrorContains(t, err, "Invalid file name template")
+
+	template = filepath.Join(dir, "{{.Input}}-{{.Index}}.trace")
+	files, err := Split(input, SplitOptions{By: SplitByTrace, Template: template})
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "up-0.trace"), filepath.Join(dir, "up-1.trace")}, files)
+	assert.Len(t, splitSpanNames(t, files[0]), len(spans))
+	assert.Equal(t, []string{"pulumi"}, splitSpanNames(t, files[1]))
+
+	files, err = Split(input, SplitOptions{By: SplitByProcess, Template: filepath.Join(dir, "{{.Key}}.trace")})
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "pulumi.trace"), filepath.Join(dir, "pulumi-resource-aws.trace")}, files)
+	assert.Equal(t, []string{"/pulumirpc.ResourceProvider/Create", "pulumi-resource-aws"}, splitSpanNames(t, files[1]))
+
+	// The plugin span starts a trace of its own.
+	traces, err := readTracesFromFile(files[1])
+	require.NoError(t, err)
+	require.Len(t, traces, 1)
+	assert.Equal(t, appdash.SpanID{Trace: 8, Span: 8}, traces[0].Span.ID)
+	assert.Len(t, traces[0].Sub, 1)
+
+	files, err = Split(input, SplitOptions{By: SplitByWindow, Window: 5 * time.Second, Template: template})
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	assert.Equal(t, []string{
+		"/pulumirpc.ResourceMonitor/RegisterResource",
+		"/pulumirpc.ResourceMonitor/RegisterResource",
+		"/pulumirpc.ResourceProvider/Create",
+		"/pulumirpc.ResourceProvider/Create",
+		"pulumi",
+		"pulumi-plan",
+		"pulumi-resource-aws",
+	}, splitSpanNames(t, files[0]))
+	assert.Equal(t, []string{"/pulumirpc.Engine/Log", "api/patchCheckpoint"}, splitSpanNames(t, files[1]))
+	assert.Equal(t, []string{"pulumi"}, splitSpanNames(t, files[2]))
+
+	_, err = Split(input, SplitOptions{By: SplitByProcess, Template: filepath.Join(dir, "same.trace")})
+	assert.ErrorContains(t, err, "for both pulumi and pulumi-resource-aws")
+
+	_, err = Split(input, SplitOptions{By: SplitByWindow})
+	assert.Error(t, err)
+}
@@ -2,29 +2,391 @@ package traces
 
 import (
 	"bufio"
+	"encoding/binary"
 	"encoding/csv"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 	"sourcegraph.com/sourcegraph/appdash"
 )
 
+type ToCsvOptions struct {
+	// Column to write the trace filename to; none if empty.
+	FilenameColumn string
+
+	// Maximum number of trace files decoded at the same time; defaults
+	// to the number of CPUs.
+	Jobs int
+
+	// Order of the annotation columns; by default, see orderColumns.
+	// The filename column always comes last.
+	Columns *ColumnOrder
+
+	// Add the span tree columns (see spanIDColumn) computed from the
+	// span hierarchy. This needs every trace file in memory in full,
+	// so it is off by default.
+	TreeColumns bool
+}
+
 func ToCsv(inputTraceFiles []string, outputCsvFile string, filenameColumn string) error {
-	annotationNames, err := detectAnnotationNames(inputTraceFiles)
+	return ToCsvWithOptions(inputTraceFiles, outputCsvFile, ToCsvOptions{FilenameColumn: filenameColumn})
+}
+
+// Writes a CSV row for every span of the trace files, with a column per
+// annotation name found in any of the files.
+//
+// Every file is decoded once: a pool of workers streams the spans of
+// the files concurrently and spools their rows to temporary files,
+// which are then copied into the output in the order the files were
+// given. Appdash files are decoded span by span (see
+// streamSpansFromFile) and only the sort key of every span is kept in
+// memory, so memory use grows little with their size.
+func ToCsvWithOptions(inputTraceFiles []string, outputCsvFile string, opts ToCsvOptions) error {
+	dir, err := os.MkdirTemp("", "pulumi-trace-tool")
 	if err != nil {
 		return err
 	}
+	defer func() { noErr(os.RemoveAll(dir)) }()
 
-	return writeTracesCsv(annotationNames, inputTraceFiles, outputCsvFile, filenameColumn)
-}
+	spools, err := spoolTraceRows(inputTraceFiles, dir, opts)
+	if err != nil {
+		return err
+	}
 
-func writeTracesCsv(annotationNames []string, inputTraceFiles []string, outputCsvFile, filenameColumn string) error {
-	f, err := os.Create(outputCsvFile)
+	var observed []string
+	seen := make(map[string]bool)
+	for _, s := range spools {
+		for _, a := range s.annotationNames {
+			if !seen[a] {
+				seen[a] = true
+				observed = append(observed, a)
+			}
+		}
+	}
+
+	annotationNames, err := opts.Columns.apply(observed)
 	if err != nil {
 		return err
 	}
+
+	return writeTracesCsv(annotationNames, spools, outputCsvFile, opts.FilenameColumn)
+}
+
+// Rows of one trace file, spooled to disk.
+type traceRowsSpool struct {
+	traceFile       string
+	spoolFile       string
+	annotationNames []string
+}
+
+// Decodes the trace files with a pool of opts.Jobs workers, spooling
+// their rows into dir. Results are in the order of the trace files. No
+// more files are started once one fails.
+func spoolTraceRows(traceFiles []string, dir string, opts ToCsvOptions) ([]traceRowsSpool, error) {
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	spools := make([]traceRowsSpool, len(traceFiles))
+	errs := make([]error, len(traceFiles))
+
+	work := make(chan int)
+	done := make(chan struct{})
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	for w := 0; w < jobs; w++ {
+		go func() {
+			for i := range work {
+				select {
+				case <-failed:
+					continue
+				default:
+				}
+				spool := traceRowsSpool{
+					traceFile: traceFiles[i],
+					spoolFile: filepath.Join(dir, fmt.Sprintf("%d.gob", i)),
+				}
+				names, err := spoolTraceFileRows(spool.traceFile, spool.spoolFile, opts.TreeColumns)
+				spool.annotationNames = names
+				spools[i], errs[i] = spool, err
+				if err != nil {
+					failOnce.Do(func() { close(failed) })
+				}
+			}
+			done <- struct{}{}
+		}()
+	}
+
+dispatch:
+	for i := range traceFiles {
+		select {
+		case work <- i:
+		case <-failed:
+			break dispatch
+		}
+	}
+	close(work)
+	for w := 0; w < jobs; w++ {
+		<-done
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %w", traceFiles[i], err)
+		}
+	}
+	return spools, nil
+}
+
+// Writes the annotations of every span of the trace file to the spool
+// file, ordered by start time (see streamSortedSpanRows) or, with the
+// tree columns, in tree order. Returns the column names found in the
+// file.
+func spoolTraceFileRows(traceFile, spoolFile string, treeColumns bool) ([]string, error) {
+	f, err := os.Create(spoolFile)
+	if err != nil {
+		return nil, err
+	}
 	defer f.Close()
 
 	out := bufio.NewWriter(f)
+	enc := gob.NewEncoder(out)
+	names := make(map[string]bool)
+
+	walkRows := func(onRow func(map[string]string) error) error {
+		return streamSortedSpanRows(traceFile, spoolFile+".unsorted", onRow)
+	}
+	if treeColumns {
+		walkRows = func(onRow func(map[string]string) error) error {
+			return walkSpanTreeRows(traceFile, onRow)
+		}
+	}
+
+	err = walkRows(func(m map[string]string) error {
+		for k := range m {
+			names[k] = true
+		}
+		return enc.Encode(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := out.Flush(); err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for k := range names {
+		res = append(res, k)
+	}
+	return res, nil
+}
+
+// Where a span row was spooled by streamSortedSpanRows, with its sort
+// key.
+type spanRowRef struct {
+	start  time.Time
+	timed  bool
+	id     appdash.SpanID
+	offset int64
+	size   int
+}
+
+// Yields the annotations of every span of the trace file ordered like
+// sortTracesByStart: timed spans by start time first, then by trace
+// and span ID. Appdash files yield their spans in arbitrary map order,
+// so the rows are first spooled to tmpFile and read back in order,
+// keeping only their offsets in memory.
+func streamSortedSpanRows(traceFile, tmpFile string, onRow func(map[string]string) error) error {
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		contract.IgnoreClose(f)
+		noErr(os.Remove(tmpFile))
+	}()
+
+	out := bufio.NewWriter(f)
+	var refs []spanRowRef
+	var offset int64
+	var buf []byte
+	err = streamSpansFromFile(traceFile, func(span *appdash.Span) error {
+		m := span.Annotations.StringMap()
+		ref := spanRowRef{id: span.ID, offset: offset}
+		if start, err := spanStart(m); err == nil {
+			ref.start, ref.timed = start, true
+		}
+
+		buf = appendSpanRow(buf[:0], m)
+		ref.size = len(buf)
+		offset += int64(len(buf))
+		refs = append(refs, ref)
+		_, err := out.Write(buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := refs[i], refs[j]
+		switch {
+		case a.timed != b.timed:
+			return a.timed
+		case !a.start.Equal(b.start):
+			return a.start.Before(b.start)
+		case a.id.Trace != b.id.Trace:
+			return a.id.Trace < b.id.Trace
+		default:
+			return a.id.Span < b.id.Span
+		}
+	})
+
+	for _, ref := range refs {
+		if cap(buf) < ref.size {
+			buf = make([]byte, ref.size)
+		}
+		buf = buf[:ref.size]
+		if _, err := f.ReadAt(buf, ref.offset); err != nil {
+			return err
+		}
+		m, err := decodeSpanRow(buf)
+		if err != nil {
+			return err
+		}
+		if err := onRow(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encodes a row as its number of entries followed by the length and
+// bytes of every key and value.
+func appendSpanRow(buf []byte, m map[string]string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(m)))
+	for k, v := range m {
+		buf = binary.AppendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+	}
+	return buf
+}
+
+func decodeSpanRow(buf []byte) (map[string]string, error) {
+	next := func() (string, error) {
+		n, size := binary.Uvarint(buf)
+		if size <= 0 || n > uint64(len(buf)-size) {
+			return "", fmt.Errorf("corrupt spooled row")
+		}
+		s := string(buf[size : size+int(n)])
+		buf = buf[size+int(n):]
+		return s, nil
+	}
+
+	count, size := binary.Uvarint(buf)
+	if size <= 0 {
+		return nil, fmt.Errorf("corrupt spooled row")
+	}
+	buf = buf[size:]
+	m := make(map[string]string, count)
+	for i := uint64(0); i < count; i++ {
+		k, err := next()
+		if err != nil {
+			return nil, err
+		}
+		v, err := next()
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// Columns describing the position of a span in its tree.
+const (
+	spanIDColumn     = "SpanID"
+	parentIDColumn   = "ParentID"
+	traceIDColumn    = "TraceID"
+	depthColumn      = "Depth"
+	rootNameColumn   = "RootName"
+	childCountColumn = "ChildCount"
+	durationColumn   = "DurationMs"
+	selfTimeColumn   = "SelfTimeMs"
+)
+
+// Yields the annotations of every span with the tree columns added. IDs
+// are hex as in appdash; durations are empty for spans without timing.
+func walkSpanTreeRows(traceFile string, onRow func(map[string]string) error) error {
+	traces, err := readTracesFromFile(traceFile)
+	if err != nil {
+		return err
+	}
+
+	return walkSpanNodes(buildSpanTree(traces), func(n *spanNode) error {
+		m := n.trace.Span.Annotations.StringMap()
+
+		id := n.trace.Span.ID
+		m[spanIDColumn] = id.Span.String()
+		m[traceIDColumn] = id.Trace.String()
+		m[parentIDColumn] = ""
+		if id.Parent != 0 {
+			m[parentIDColumn] = id.Parent.String()
+		}
+
+		root := n
+		for root.parent != nil {
+			root = root.parent
+		}
+		m[depthColumn] = strconv.Itoa(n.depth)
+		m[rootNameColumn] = root.name
+		m[childCountColumn] = strconv.Itoa(len(n.children))
+
+		m[durationColumn] = ""
+		m[selfTimeColumn] = ""
+		if n.timed {
+			m[durationColumn] = ms(n.duration())
+			m[selfTimeColumn] = ms(n.selfTime())
+		}
+
+		return onRow(m)
+	})
+}
+
+// Writes the CSV file, compressed if its extension asks for it (see
+// CreateOutputFile).
+func writeTracesCsv(annotationNames []string, spools []traceRowsSpool, outputCsvFile, filenameColumn string) error {
+	f, err := CreateOutputFile(outputCsvFile)
+	if err != nil {
+		return err
+	}
+	if err := writeTracesCsvRows(f, annotationNames, spools, filenameColumn); err != nil {
+		contract.IgnoreClose(f)
+		return err
+	}
+	return f.Close()
+}
+
+func writeTracesCsvRows(output io.Writer, annotationNames []string, spools []traceRowsSpool, filenameColumn string) error {
+	out := bufio.NewWriter(output)
 	w := csv.NewWriter(out)
 
 	var columns []string
@@ -33,61 +395,55 @@ func writeTracesCsv(annotationNames []string, inputTraceFiles []string, outputCs
 		columns = append(columns, filenameColumn)
 	}
 
-	err = w.Write(columns)
-	if err != nil {
+	if err := w.Write(columns); err != nil {
 		return err
 	}
 
-	i := 0
-
-	for _, inputTraceFile := range inputTraceFiles {
-		writeTrace := func(t *appdash.Trace) error {
-			i = i + 1
-			if i%1024 == 0 {
-				w.Flush()
-			}
+	for _, spool := range spools {
+		writeRow := func(m map[string]string) error {
 			var values []string
-			m := t.Span.Annotations.StringMap()
 
 			for _, a := range annotationNames {
 				values = append(values, m[a])
 			}
 
 			if filenameColumn != "" {
-				values = append(values, inputTraceFile)
+				values = append(values, spool.traceFile)
 			}
 
 			return w.Write(values)
 		}
 
-		if err := walkTracesFromFile(inputTraceFile, writeTrace); err != nil {
+		if err := readSpooledRows(spool.spoolFile, writeRow); err != nil {
 			return err
 		}
 	}
 
 	w.Flush()
-	return nil
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return out.Flush()
 }
 
-func detectAnnotationNames(inputTraceFiles []string) ([]string, error) {
-	annotations := make(map[string]int)
-
-	detectAnnotations := func(t *appdash.Trace) error {
-		for k := range t.Span.Annotations.StringMap() {
-			annotations[k] = annotations[k] + 1
-		}
-		return nil
+func readSpooledRows(spoolFile string, onRow func(map[string]string) error) error {
+	f, err := os.Open(spoolFile)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	for _, inputTraceFile := range inputTraceFiles {
-		if err := walkTracesFromFile(inputTraceFile, detectAnnotations); err != nil {
-			return nil, err
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	for {
+		var m map[string]string
+		if err := dec.Decode(&m); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := onRow(m); err != nil {
+			return err
 		}
 	}
-
-	var res []string
-	for k := range annotations {
-		res = append(res, k)
-	}
-	return res, nil
 }
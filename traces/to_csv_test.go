@@ -0,0 +1,107 @@
+package traces
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func TestToCsvDeterministic(t *testing.T) {
+	var files []string
+	for i := 0; i < 8; i++ {
+		files = append(files, writeTestTrace(t, fmt.Sprintf("up-%d.trace", i), testPulumiSpans()))
+	}
+
+	dir := t.TempDir()
+	var outputs [][]byte
+	for _, jobs := range []int{1, 4} {
+		out := filepath.Join(dir, fmt.Sprintf("traces-%d.csv", jobs))
+		require.NoError(t, ToCsvWithOptions(files, out, ToCsvOptions{FilenameColumn: "file", Jobs: jobs}))
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+		outputs = append(outputs, data)
+	}
+	assert.Equal(t, string(outputs[0]), string(outputs[1]))
+
+	var names, rowFiles []string
+	f, err := os.Open(filepath.Join(dir, "traces-4.csv"))
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, readCsv(f, func(row map[string]string) error {
+		names = append(names, row["Name"])
+		rowFiles = append(rowFiles, row["file"])
+		return nil
+	}))
+
+	// Rows of a file are ordered by start time.
+	require.Len(t, names, 8*7)
+	assert.Equal(t, []string{
+		"pulumi", "pulumi-plan", "/pulumirpc.ResourceMonitor/RegisterResource",
+		"/pulumirpc.ResourceMonitor/RegisterResource", "/pulumirpc.ResourceProvider/Create",
+		"/pulumirpc.Engine/Log", "api/patchCheckpoint",
+	}, names[:7])
+	for i := 7; i < len(names); i += 7 {
+		assert.Equal(t, names[:7], names[i:i+7])
+	}
+	for i, f := range rowFiles {
+		assert.Equal(t, files[i/7], f)
+	}
+}
+
+func TestToCsvTreeColumns(t *testing.T) {
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+	out := filepath.Join(t.TempDir(), "traces.csv")
+	require.NoError(t, ToCsvWithOptions([]string{file}, out, ToCsvOptions{TreeColumns: true}))
+
+	f, err := os.Open(out)
+	require.NoError(t, err)
+	defer f.Close()
+
+	rows := make(map[string]map[string]string)
+	require.NoError(t, readCsv(f, func(row map[string]string) error {
+		rows[row[spanIDColumn]] = row
+		return nil
+	}))
+	require.Len(t, rows, 7)
+
+	root := rows[appdash.ID(1).String()]
+	assert.Equal(t, "", root[parentIDColumn])
+	assert.Equal(t, "0", root[depthColumn])
+	assert.Equal(t, "2", root[childCountColumn])
+	assert.Equal(t, "10000", root[durationColumn])
+	assert.Equal(t, "1500", root[selfTimeColumn])
+
+	create := rows[appdash.ID(5).String()]
+	assert.Equal(t, appdash.ID(4).String(), create[parentIDColumn])
+	assert.Equal(t, appdash.ID(1).String(), create[traceIDColumn])
+	assert.Equal(t, "3", create[depthColumn])
+	assert.Equal(t, "pulumi", create[rootNameColumn])
+	assert.Equal(t, "3500", create[durationColumn])
+
+	// RegisterResource 4 runs 3000-8000 with Create 4000-7500 inside.
+	assert.Equal(t, "1500", rows[appdash.ID(4).String()][selfTimeColumn])
+}
+
+func TestToCsvStopsAfterError(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.trace")
+	require.NoError(t, os.WriteFile(bad, []byte("not a trace"), 0o600))
+	files := []string{bad}
+	for i := 0; i < 4; i++ {
+		files = append(files, writeTestTrace(t, fmt.Sprintf("up-%d.trace", i), testPulumiSpans()))
+	}
+
+	dir := t.TempDir()
+	_, err := spoolTraceRows(files, dir, ToCsvOptions{Jobs: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), bad)
+
+	// Only the failed file was started.
+	spooled, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, spooled, 1)
+}
@@ -0,0 +1,130 @@
+// Exports traces in the JSON format accepted by the "JSON File" upload
+// of the Jaeger UI, which is also what the Jaeger query API returns.
+
+package traces
+
+import (
+	"fmt"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+type jaegerData struct {
+	Data []jaegerTrace `json:"data"`
+}
+
+type jaegerTrace struct {
+	TraceID   string                   `json:"traceID"`
+	Spans     []jaegerSpan             `json:"spans"`
+	Processes map[string]jaegerProcess `json:"processes"`
+}
+
+type jaegerSpan struct {
+	TraceID       string            `json:"traceID"`
+	SpanID        string            `json:"spanID"`
+	OperationName string            `json:"operationName"`
+	References    []jaegerReference `json:"references"`
+	StartTime     int64             `json:"startTime"`
+	Duration      int64             `json:"duration"`
+	Tags          []jaegerKeyValue  `json:"tags"`
+	Logs          []jaegerLog       `json:"logs"`
+	ProcessID     string            `json:"processID"`
+}
+
+type jaegerReference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type jaegerKeyValue struct {
+	Key   string `json:"key"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type jaegerLog struct {
+	Timestamp int64            `json:"timestamp"`
+	Fields    []jaegerKeyValue `json:"fields"`
+}
+
+type jaegerProcess struct {
+	ServiceName string           `json:"serviceName"`
+	Tags        []jaegerKeyValue `json:"tags"`
+}
+
+// Converts a trace file to Jaeger JSON. Writes to stdout when
+// outputFile is empty.
+//
+// Parent links become `CHILD_OF` references, annotations become string
+// tags, appdash log events become span logs and every Pulumi process
+// (the CLI or a plugin) becomes a Jaeger process.
+func ToJaeger(inputTraceFile, outputFile string) error {
+	traces, err := readTracesFromFile(inputTraceFile)
+	if err != nil {
+		return err
+	}
+
+	data := jaegerData{Data: []jaegerTrace{}}
+	traceIndex := make(map[appdash.ID]int)
+
+	for _, s := range exportSpans(traces) {
+		traceID := s.id.Trace.String()
+
+		i, ok := traceIndex[s.id.Trace]
+		if !ok {
+			i = len(data.Data)
+			traceIndex[s.id.Trace] = i
+			data.Data = append(data.Data, jaegerTrace{
+				TraceID:   traceID,
+				Spans:     []jaegerSpan{},
+				Processes: make(map[string]jaegerProcess),
+			})
+		}
+		jt := &data.Data[i]
+
+		processID := ""
+		for id, p := range jt.Processes {
+			if p.ServiceName == s.process {
+				processID = id
+			}
+		}
+		if processID == "" {
+			processID = fmt.Sprintf("p%d", len(jt.Processes)+1)
+			jt.Processes[processID] = jaegerProcess{ServiceName: s.process, Tags: []jaegerKeyValue{}}
+		}
+
+		span := jaegerSpan{
+			TraceID:       traceID,
+			SpanID:        s.id.Span.String(),
+			OperationName: s.name,
+			References:    []jaegerReference{},
+			StartTime:     s.interval.Start.UnixNano() / int64(time.Microsecond),
+			Duration:      int64(s.interval.End.Sub(s.interval.Start) / time.Microsecond),
+			Tags:          []jaegerKeyValue{},
+			Logs:          []jaegerLog{},
+			ProcessID:     processID,
+		}
+		if s.id.Parent != 0 {
+			span.References = append(span.References, jaegerReference{
+				RefType: "CHILD_OF",
+				TraceID: traceID,
+				SpanID:  s.id.Parent.String(),
+			})
+		}
+		for _, a := range s.tags {
+			span.Tags = append(span.Tags, jaegerKeyValue{Key: a.Key, Type: "string", Value: string(a.Value)})
+		}
+		for _, l := range s.logs {
+			span.Logs = append(span.Logs, jaegerLog{
+				Timestamp: l.time.UnixNano() / int64(time.Microsecond),
+				Fields:    []jaegerKeyValue{{Key: "message", Type: "string", Value: l.msg}},
+			})
+		}
+
+		jt.Spans = append(jt.Spans, span)
+	}
+
+	return writeJSONOutput(outputFile, data)
+}
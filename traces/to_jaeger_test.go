@@ -0,0 +1,58 @@
+package traces
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToJaeger(t *testing.T) {
+	spans := append(testPulumiSpans(),
+		testSpan{id: 8, parent: 5, name: "pulumi-resource-aws", start: 4100, end: 7400})
+	file := writeTestTrace(t, "up.trace", spans)
+	out := filepath.Join(t.TempDir(), "up.json")
+	require.NoError(t, ToJaeger(file, out))
+
+	bytes, err := os.ReadFile(out)
+	require.NoError(t, err)
+	var data jaegerData
+	require.NoError(t, json.Unmarshal(bytes, &data))
+
+	require.Len(t, data.Data, 1)
+	trace := data.Data[0]
+	require.Len(t, trace.Spans, 8)
+	require.Len(t, trace.Processes, 2)
+
+	byName := make(map[string]jaegerSpan)
+	for _, s := range trace.Spans {
+		assert.Equal(t, trace.TraceID, s.TraceID)
+		byName[s.OperationName] = s
+	}
+
+	root := byName["pulumi"]
+	assert.Empty(t, root.References)
+	assert.Equal(t, testTraceStart.UnixNano()/int64(time.Microsecond), root.StartTime)
+	assert.Equal(t, int64(10*time.Second/time.Microsecond), root.Duration)
+	assert.Contains(t, root.Tags, jaegerKeyValue{Key: "benchmark_name", Type: "string", Value: "test"})
+	assert.Equal(t, "pulumi", trace.Processes[root.ProcessID].ServiceName)
+
+	plan := byName["pulumi-plan"]
+	assert.Equal(t, []jaegerReference{{RefType: "CHILD_OF", TraceID: trace.TraceID, SpanID: root.SpanID}}, plan.References)
+	assert.Equal(t, root.ProcessID, plan.ProcessID)
+
+	plugin := byName["pulumi-resource-aws"]
+	assert.Equal(t, "pulumi-resource-aws", trace.Processes[plugin.ProcessID].ServiceName)
+
+	log := byName["/pulumirpc.Engine/Log"]
+	require.Len(t, log.Logs, 1)
+	assert.Equal(t, testTraceStart.Add(6*time.Second).UnixNano()/int64(time.Microsecond), log.Logs[0].Timestamp)
+	assert.Equal(t, []jaegerKeyValue{{Key: "message", Type: "string", Value: "hello"}}, log.Logs[0].Fields)
+	for _, tag := range log.Tags {
+		assert.NotEqual(t, "Msg", tag.Key)
+	}
+}
@@ -0,0 +1,207 @@
+// Exports traces as OTLP JSON so that archived `pulumi --tracing`
+// files can be loaded into standard tracing backends.
+
+package traces
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-trace-tool/intervals"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Instrumentation scope reported for exported spans.
+const exportScopeName = "pulumi-trace-tool"
+
+// A span flattened out of the appdash span tree for export.
+type exportSpan struct {
+	id       appdash.SpanID
+	name     string
+	process  string
+	interval intervals.Interval
+	tags     []appdash.Annotation
+	logs     []exportLog
+}
+
+type exportLog struct {
+	time time.Time
+	msg  string
+}
+
+// Flattens the span trees into a list of spans, keeping the process
+// each span belongs to. Spans without timing information are skipped,
+// and their children are re-parented to the nearest exported ancestor
+// so that no span refers to a parent missing from the export.
+func exportSpans(traces []*appdash.Trace) []exportSpan {
+	var spans []exportSpan
+
+	var visit func(t *appdash.Trace, parentProcess string, parent appdash.ID)
+	visit = func(t *appdash.Trace, parentProcess string, parent appdash.ID) {
+		process := spanProcessName(t, parentProcess)
+		if iv, err := traceInterval(t); err == nil {
+			tags, logs := splitLogAnnotations(t.Span.Annotations)
+			for i := range logs {
+				if logs[i].time.IsZero() {
+					logs[i].time = iv.Start
+				}
+			}
+			id := t.Span.ID
+			id.Parent = parent
+			parent = id.Span
+			spans = append(spans, exportSpan{
+				id:       id,
+				name:     t.Span.Name(),
+				process:  process,
+				interval: iv,
+				tags:     tags,
+				logs:     logs,
+			})
+		}
+		for _, sub := range sortTracesByStart(t.Sub) {
+			visit(sub, process, parent)
+		}
+	}
+
+	for _, t := range sortTracesByStart(traces) {
+		visit(t, "", t.Span.ID.Parent)
+	}
+
+	return spans
+}
+
+// Separates the `Msg`/`Time` pairs recorded by appdash log events from
+// the other span annotations. Event bookkeeping annotations (schema
+// markers, span name and timespan) are dropped.
+func splitLogAnnotations(anns appdash.Annotations) ([]appdash.Annotation, []exportLog) {
+	var tags []appdash.Annotation
+	var logs []exportLog
+
+	for _, a := range anns {
+		switch {
+		case a.Key == "Msg":
+			logs = append(logs, exportLog{msg: string(a.Value)})
+		case a.Key == "Time" && len(logs) > 0:
+			if t, err := time.Parse(time.RFC3339Nano, string(a.Value)); err == nil {
+				logs[len(logs)-1].time = t
+			}
+		case isEventAnnotation(a.Key) || a.Key == "Name":
+		default:
+			tags = append(tags, a)
+		}
+	}
+
+	return tags, logs
+}
+
+// Converts a trace file to OTLP JSON (an `ExportTraceServiceRequest`).
+// Writes to stdout when outputFile is empty.
+func ToOtlp(inputTraceFile, outputFile string) error {
+	data, err := otlpTracesData(inputTraceFile)
+	if err != nil {
+		return err
+	}
+
+	return writeJSONOutput(outputFile, data)
+}
+
+// Sends the spans of a trace file to an OTLP/HTTP endpoint such as a
+// local OpenTelemetry Collector, Jaeger or Tempo, for example
+// `http://localhost:4318`. The `/v1/traces` path is appended unless the
+// endpoint already ends with it.
+func PushOtlp(inputTraceFile, endpoint string) error {
+	data, err := otlpTracesData(inputTraceFile)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body)) //nolint:gosec
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("OTLP endpoint %s responded with %s: %s", url, resp.Status, msg)
+	}
+
+	return nil
+}
+
+// Groups the spans into one resource per process, with the process
+// name as the `service.name`.
+func otlpTracesData(inputTraceFile string) (otlpJsonTracesData, error) {
+	traces, err := readTracesFromFile(inputTraceFile)
+	if err != nil {
+		return otlpJsonTracesData{}, err
+	}
+
+	var data otlpJsonTracesData
+	resources := make(map[string]int)
+
+	for _, s := range exportSpans(traces) {
+		i, ok := resources[s.process]
+		if !ok {
+			i = len(data.ResourceSpans)
+			resources[s.process] = i
+			rs := otlpJsonResourceSpans{
+				ScopeSpans: []otlpJsonScopeSpans{{Scope: &otlpJsonScope{Name: exportScopeName}}},
+			}
+			rs.Resource.Attributes = otlpJsonStringAttributes([]appdash.Annotation{
+				{Key: "service.name", Value: []byte(s.process)},
+			})
+			data.ResourceSpans = append(data.ResourceSpans, rs)
+		}
+
+		span := otlpJsonSpan{
+			TraceID:           fmt.Sprintf("%032x", uint64(s.id.Trace)),
+			SpanID:            s.id.Span.String(),
+			Name:              s.name,
+			StartTimeUnixNano: otlpJsonUint64(s.interval.Start.UnixNano()),
+			EndTimeUnixNano:   otlpJsonUint64(s.interval.End.UnixNano()),
+			Attributes:        otlpJsonStringAttributes(s.tags),
+		}
+		if s.id.Parent != 0 {
+			span.ParentSpanID = s.id.Parent.String()
+		}
+		for _, l := range s.logs {
+			span.Events = append(span.Events, otlpJsonEvent{
+				TimeUnixNano: otlpJsonUint64(l.time.UnixNano()),
+				Name:         "log",
+				Attributes: otlpJsonStringAttributes([]appdash.Annotation{
+					{Key: "message", Value: []byte(l.msg)},
+				}),
+			})
+		}
+
+		scope := &data.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	return data, nil
+}
+
+func otlpJsonStringAttributes(anns []appdash.Annotation) otlpJsonAttributes {
+	var attrs otlpJsonAttributes
+	for _, a := range anns {
+		v := string(a.Value)
+		attrs = append(attrs, otlpJsonKeyValue{Key: a.Key, Value: otlpJsonValue{StringValue: &v}})
+	}
+	return attrs
+}
@@ -1,20 +1,38 @@
 package traces
 
+// Converts a CSV file to Parquet. Columns of the default metrics spec
+// keep their declared types so that metrics files always get the same
+// schema; the types of all other columns are inferred from their values.
 func ToParquet(inputCsvFile, outputParquetFile string) error {
+	return ToParquetWithSchema(inputCsvFile, outputParquetFile, nil)
+}
+
+// Like ToParquet, but writes exactly the columns of the schema if it is
+// not nil.
+func ToParquetWithSchema(inputCsvFile, outputParquetFile string, schema *ParquetSchema) error {
 	var data []map[string]string
 	err := readLargeCsvFile(inputCsvFile, func(row map[string]string) error {
 		data = append(data, row)
 		return nil
 	})
 	if err != nil {
-		return nil
+		return err
 	}
 
-	sink := NewParquetFileMetricsSink(outputParquetFile)
-	err = sink.writeMetrics(data)
-	if err != nil {
-		return err
+	if schema != nil {
+		return writeParquetMetrics(outputParquetFile, schema.Columns, data)
+	}
+
+	present := make(map[string]bool)
+	for _, c := range metricsColumns(data) {
+		present[c] = true
+	}
+	var known []ParquetColumn
+	for _, c := range DefaultMetricsSpec().columns() {
+		if present[c.Name] {
+			known = append(known, c)
+		}
 	}
 
-	return nil
+	return writeParquetMetrics(outputParquetFile, inferParquetColumns(known, data), data)
 }
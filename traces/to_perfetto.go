@@ -0,0 +1,244 @@
+// Exports traces in the Chrome Trace Event Format so they can be
+// viewed in ui.perfetto.dev or chrome://tracing.
+
+package traces
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-trace-tool/intervals"
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// A single entry of the `traceEvents` array, see
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type perfettoEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat,omitempty"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Dur  float64           `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+type perfettoTrace struct {
+	TraceEvents     []perfettoEvent `json:"traceEvents"`
+	DisplayTimeUnit string          `json:"displayTimeUnit"`
+}
+
+// Converts a trace file to a Chrome Trace Event Format JSON file.
+// Writes to stdout when outputFile is empty.
+//
+// Every span becomes a complete ("X") event with its annotations as
+// args. Spans are grouped into one process lane per Pulumi process
+// (the CLI or a plugin). Within a process, children are laid out on
+// the thread of their parent so that nesting shows as stack depth;
+// children running concurrently with a sibling are moved to separate
+// threads, as the format requires events on a thread to nest.
+func ToPerfetto(inputTraceFile, outputFile string) error {
+	traces, err := readTracesFromFile(inputTraceFile)
+	if err != nil {
+		return err
+	}
+
+	c := newPerfettoConverter()
+	for _, t := range sortTracesByStart(traces) {
+		c.convert(t, "", 0)
+	}
+
+	return writeJSONOutput(outputFile, perfettoTrace{
+		TraceEvents:     c.events,
+		DisplayTimeUnit: "ms",
+	})
+}
+
+type perfettoConverter struct {
+	events []perfettoEvent
+	pids   map[string]int
+
+	// For every process, the time until which each of its threads
+	// is occupied by a top-level event.
+	threads map[int][]time.Time
+}
+
+func newPerfettoConverter() *perfettoConverter {
+	return &perfettoConverter{
+		pids:    make(map[string]int),
+		threads: make(map[int][]time.Time),
+	}
+}
+
+func (c *perfettoConverter) pid(process string) int {
+	pid, ok := c.pids[process]
+	if !ok {
+		pid = len(c.pids) + 1
+		c.pids[process] = pid
+		c.events = append(c.events, perfettoEvent{
+			Name: "process_name",
+			Ph:   "M",
+			Pid:  pid,
+			Args: map[string]string{"name": process},
+		})
+	}
+	return pid
+}
+
+// Finds a thread of the process that is free at the given time, or
+// allocates a new one, and marks it busy until end.
+func (c *perfettoConverter) freeThread(pid int, iv intervals.Interval) int {
+	busy := c.threads[pid]
+	for i, until := range busy {
+		if !until.After(iv.Start) {
+			busy[i] = iv.End
+			return i + 1
+		}
+	}
+	c.threads[pid] = append(busy, iv.End)
+	return len(busy) + 1
+}
+
+// Emits the span on the given thread, or on a free thread when tid is
+// 0, and recurses into the children.
+func (c *perfettoConverter) convert(t *appdash.Trace, parentProcess string, tid int) {
+	process := spanProcessName(t, parentProcess)
+	if process != parentProcess {
+		tid = 0
+	}
+	pid := c.pid(process)
+
+	iv, err := traceInterval(t)
+	if err != nil {
+		// Spans without timing cannot be drawn; keep their
+		// children on the parent's lanes.
+		for _, sub := range sortTracesByStart(t.Sub) {
+			c.convert(sub, process, tid)
+		}
+		return
+	}
+
+	if tid == 0 {
+		tid = c.freeThread(pid, iv)
+	}
+
+	args := make(map[string]string)
+	for k, v := range t.Span.Annotations.StringMap() {
+		if !isEventAnnotation(k) && k != "Name" {
+			args[k] = v
+		}
+	}
+
+	c.events = append(c.events, perfettoEvent{
+		Name: t.Span.Name(),
+		Cat:  spanCategory(t.Span.Name()),
+		Ph:   "X",
+		Ts:   micros(iv.Start),
+		Dur:  float64(iv.End.Sub(iv.Start)) / float64(time.Microsecond),
+		Pid:  pid,
+		Tid:  tid,
+		Args: args,
+	})
+
+	// Children nest under this event on the same thread unless they
+	// overlap an earlier sibling or spill outside of this span.
+	var lastEnd time.Time
+	for _, sub := range sortTracesByStart(t.Sub) {
+		subTid := 0
+		if subIv, err := traceInterval(sub); err == nil {
+			nested := !subIv.Start.Before(iv.Start) && !subIv.End.After(iv.End)
+			if nested && !subIv.Start.Before(lastEnd) {
+				subTid = tid
+				lastEnd = subIv.End
+			}
+		}
+		c.convert(sub, process, subTid)
+	}
+}
+
+// Determines the Pulumi process a span was recorded in. Root spans
+// and plugin root spans such as `pulumi-resource-aws` start a new
+// process; an explicit `pulumi_process` annotation wins. Other spans
+// belong to the process of their parent.
+func spanProcessName(t *appdash.Trace, parentProcess string) string {
+	for _, a := range t.Span.Annotations {
+		if a.Key == pulumi_process && len(a.Value) > 0 {
+			return string(a.Value)
+		}
+	}
+	name := t.Span.Name()
+	if parentProcess == "" || isPluginProcessName(name) {
+		if name == "" {
+			return "unknown"
+		}
+		return name
+	}
+	return parentProcess
+}
+
+func isPluginProcessName(name string) bool {
+	for _, prefix := range []string{"pulumi-resource-", "pulumi-language-", "pulumi-analyzer-", "pulumi-converter-"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Uses the gRPC service as the category of `/pulumirpc.Service/Method`
+// spans, and the prefix of `api/method` spans.
+func spanCategory(name string) string {
+	if strings.HasPrefix(name, "/") {
+		if parts := strings.Split(name, "/"); len(parts) == 3 {
+			return parts[1]
+		}
+	}
+	if i := strings.Index(name, "/"); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// Annotations that encode appdash events rather than span data.
+func isEventAnnotation(key string) bool {
+	return strings.HasPrefix(key, appdash.SchemaPrefix) || key == "Span.Start" || key == "Span.End"
+}
+
+// Microseconds since the epoch. Current nanosecond timestamps need
+// more bits than a float64 holds, so the whole microseconds are
+// converted apart from the fraction.
+func micros(t time.Time) float64 {
+	return float64(t.UnixMicro()) + float64(t.Nanosecond()%1000)/1000
+}
+
+// Returns a copy of the traces ordered by start time; spans without
+// timing come last, ties are broken by span ID.
+func sortTracesByStart(traces []*appdash.Trace) []*appdash.Trace {
+	type keyed struct {
+		t     *appdash.Trace
+		start time.Time
+		ok    bool
+	}
+	ks := make([]keyed, len(traces))
+	for i, t := range traces {
+		iv, err := traceInterval(t)
+		ks[i] = keyed{t, iv.Start, err == nil}
+	}
+	sort.SliceStable(ks, func(i, j int) bool {
+		a, b := ks[i], ks[j]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		if !a.start.Equal(b.start) {
+			return a.start.Before(b.start)
+		}
+		return a.t.Span.ID.Span < b.t.Span.ID.Span
+	})
+	sorted := make([]*appdash.Trace, len(traces))
+	for i, k := range ks {
+		sorted[i] = k.t
+	}
+	return sorted
+}
@@ -0,0 +1,75 @@
+package traces
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToPerfetto(t *testing.T) {
+	spans := append(testPulumiSpans(),
+		testSpan{id: 8, parent: 5, name: "pulumi-resource-aws", start: 4100, end: 7400},
+		testSpan{id: 9, parent: 8, name: "/pulumirpc.ResourceProvider/Create", start: 4200, end: 7300})
+	file := writeTestTrace(t, "up.trace", spans)
+	out := filepath.Join(t.TempDir(), "up.json")
+
+	require.NoError(t, ToPerfetto(file, out))
+
+	bytes, err := os.ReadFile(out)
+	require.NoError(t, err)
+	var trace perfettoTrace
+	require.NoError(t, json.Unmarshal(bytes, &trace))
+
+	processes := map[int]string{}
+	events := map[string][]perfettoEvent{}
+	for _, e := range trace.TraceEvents {
+		if e.Ph == "M" {
+			processes[e.Pid] = e.Args["name"]
+		} else {
+			events[e.Name] = append(events[e.Name], e)
+		}
+	}
+
+	root := events["pulumi"][0]
+	plan := events["pulumi-plan"][0]
+	assert.Equal(t, "pulumi", processes[root.Pid])
+	assert.Equal(t, root.Tid, plan.Tid)
+	assert.Equal(t, 10000000.0, root.Dur)
+	assert.Equal(t, "test", root.Args["benchmark_name"])
+
+	// Overlapping siblings cannot nest on the same thread.
+	rr := events["/pulumirpc.ResourceMonitor/RegisterResource"]
+	require.Len(t, rr, 2)
+	assert.Equal(t, plan.Tid, rr[0].Tid)
+	assert.NotEqual(t, rr[0].Tid, rr[1].Tid)
+	assert.Equal(t, "pulumirpc.ResourceMonitor", rr[0].Cat)
+
+	// Plugin spans get a process lane of their own.
+	plugin := events["pulumi-resource-aws"][0]
+	assert.Equal(t, "pulumi-resource-aws", processes[plugin.Pid])
+	creates := events["/pulumirpc.ResourceProvider/Create"]
+	require.Len(t, creates, 2)
+	assert.Equal(t, root.Pid, creates[0].Pid)
+	assert.Equal(t, plugin.Pid, creates[1].Pid)
+}
+
+func TestMicros(t *testing.T) {
+	assert.Equal(t, 1672531200000001.0, micros(testTraceStart.Add(1001*time.Nanosecond)))
+	assert.Equal(t, 1672531200000000.75, micros(testTraceStart.Add(750*time.Nanosecond)))
+	assert.Equal(t, 0.5, micros(time.Unix(0, 500)))
+}
+
+func TestToPerfettoWriteError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("needs /dev/full")
+	}
+	file := writeTestTrace(t, "up.trace", testPulumiSpans())
+
+	// Writes only fail once the buffer is flushed.
+	assert.Error(t, ToPerfetto(file, "/dev/full"))
+}